@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ALERT_WARNING  = "warning"
+	ALERT_CRITICAL = "critical"
+)
+
+// Alert is a condition raised by a monitor, Key identifies the condition,
+// e.g. "fan1A.stall".
+type Alert struct {
+	Key      string
+	Severity string
+	Message  string
+	Since    time.Time
+	Active   bool
+}
+
+type AlertListener interface {
+	OnAlert(alert Alert)
+}
+
+// Alerts keeps the active alerts, logs changes and passes them to listeners.
+// Raised and cleared alerts are also appended to AppConfig.AlertLog.
+type Alerts struct {
+	logPath string
+
+	lock      sync.Mutex
+	active    map[string]Alert
+	listeners []AlertListener
+}
+
+func NewAlerts(appConfig *AppConfig) *Alerts {
+	return &Alerts{
+		logPath: appConfig.AlertLog,
+		active:  make(map[string]Alert),
+	}
+}
+
+func (a *Alerts) record(event string, alert *Alert) {
+	log.Printf("Alert %s %s: %s", event, alert.Severity, alert.Message)
+	if a.logPath == "" {
+		return
+	}
+	f, err := os.OpenFile(a.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("err=%v", err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s %s %s: %s\n", time.Now().Format(time.RFC3339), event, alert.Severity, alert.Message)
+}
+
+func (a *Alerts) AddListener(listener AlertListener) {
+	a.lock.Lock()
+	a.listeners = append(a.listeners, listener)
+	a.lock.Unlock()
+}
+
+// Raise activates the alert or updates its message, listeners are called
+// only when something changed.
+func (a *Alerts) Raise(key, severity, message string) {
+	a.lock.Lock()
+	alert, ok := a.active[key]
+	if ok && alert.Severity == severity && alert.Message == message {
+		a.lock.Unlock()
+		return
+	}
+	if !ok {
+		alert = Alert{Key: key, Since: time.Now(), Active: true}
+	}
+	changed := alert.Severity != severity
+	alert.Severity = severity
+	alert.Message = message
+	a.active[key] = alert
+	if changed {
+		a.record("raised", &alert)
+	}
+	listeners := a.listeners
+	a.lock.Unlock()
+
+	for _, l := range listeners {
+		l.OnAlert(alert)
+	}
+}
+
+func (a *Alerts) Clear(key string) {
+	a.lock.Lock()
+	alert, ok := a.active[key]
+	if !ok {
+		a.lock.Unlock()
+		return
+	}
+	delete(a.active, key)
+	a.record("cleared", &alert)
+	listeners := a.listeners
+	a.lock.Unlock()
+
+	alert.Active = false
+	for _, l := range listeners {
+		l.OnAlert(alert)
+	}
+}
+
+// Active returns the active alerts ordered by key.
+func (a *Alerts) Active() []Alert {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	alerts := make([]Alert, 0, len(a.active))
+	for _, alert := range a.active {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Key < alerts[j].Key
+	})
+	return alerts
+}
@@ -19,11 +19,53 @@ type AppConfig struct {
 	MaxRPM             int
 	MaxTemp            int
 	AutoStartInSystray bool
+	Schedule           []string
+	HTTPAddr           string
+	HTTPToken          string
+
+	HistoryInterval      int
+	HistoryRetentionDays int
+
+	TempAlarms          map[string]TempAlarm
+	TempAlarmHysteresis int
+	TempAlarmDuration   int
+	TempAlarmEmergency  bool
+	AlertLog            string
+
+	Hooks []Hook
+
+	FanCurves        []FanCurve
+	FanCurveInterval int
+	FanCurveFallback string
+	FanPIDs          []FanPID
+	FanPIDInterval   float64
+
+	HwmonRoot   string
+	HostSensors []HostSensor
+
+	StaleTimeout      int
+	StaleReconnect    int
+	LogRejectedFrames bool
+
+	MQTTBroker          string
+	MQTTUser            string
+	MQTTPassword        string
+	MQTTTopic           string
+	MQTTClientID        string
+	MQTTDiscoveryPrefix string
 }
 
 func readAppConfig(appConfig *AppConfig) {
 	appConfig.MaxRPM = MAX_RPM
 	appConfig.MaxTemp = MAX_TEMP
+	appConfig.HistoryInterval = HISTORY_INTERVAL
+	appConfig.HistoryRetentionDays = HISTORY_RETENTION_DAYS
+	appConfig.TempAlarmHysteresis = TEMP_ALARM_HYSTERESIS
+	appConfig.TempAlarmDuration = TEMP_ALARM_DURATION
+	appConfig.FanCurveInterval = FAN_CURVE_INTERVAL
+	appConfig.FanPIDInterval = FAN_PID_INTERVAL
+	appConfig.HwmonRoot = HWMON_ROOT
+	appConfig.StaleTimeout = STALE_TIMEOUT
 	if _, err := os.Stat(APP_CONFIG); !os.IsNotExist(err) {
 		if _, err := toml.DecodeFile(APP_CONFIG, appConfig); err != nil {
 			log.Printf("err=%v", err)
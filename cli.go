@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	EXIT_OK               = 0
+	EXIT_ERROR            = 1
+	EXIT_CONTROLLER_ERROR = 2
+	EXIT_TIMEOUT          = 3
+
+	CLI_TIMEOUT = time.Second * 5
+)
+
+// cliClient is the connection of a command line subcommand.
+type cliClient struct {
+	serial *Serial
+}
+
+func newCLIClient(appConfig *AppConfig) *cliClient {
+	return &cliClient{serial: NewSerial(appConfig)}
+}
+
+func cliFail(code int, format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	return code
+}
+
+// newCLIFlags adds flags shared by all subcommands talking to the controller.
+func newCLIFlags(name string, appConfig *AppConfig) (*flag.FlagSet, *string, *time.Duration) {
+	defaultPort := ""
+	if len(appConfig.Ports) > 0 {
+		defaultPort = appConfig.Ports[0]
+	}
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	portName := flags.String("port", defaultPort, "controller port, serial device, tcp://host:port or auto")
+	timeout := flags.Duration("timeout", CLI_TIMEOUT, "time to wait for the controller reply")
+	return flags, portName, timeout
+}
+
+// parseArgs parses flags placed anywhere between positional arguments and
+// returns the positional ones.
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	positional := make([]string, 0)
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// connect waits up to timeout for the first status.
+func (c *cliClient) connect(portName string, timeout time.Duration) int {
+	if portName == "" {
+		return cliFail(EXIT_ERROR, "Port isn't set, use -port or Ports in %s", APP_CONFIG)
+	}
+	portName, err := resolvePort(portName)
+	if err != nil {
+		return cliFail(EXIT_ERROR, "err=%v", err)
+	}
+	c.serial.SetCheckTimeout(timeout)
+	if err := c.serial.ConnectToController(portName); err == ErrNoStatus {
+		return cliFail(EXIT_TIMEOUT, "Couldn't connect to %s: %v", portName, err)
+	} else if err != nil {
+		return cliFail(EXIT_ERROR, "Couldn't connect to %s: %v", portName, err)
+	}
+	return EXIT_OK
+}
+
+// requestFail maps the error of a request to the exit code.
+func requestFail(err error) int {
+	switch err.(type) {
+	case *TimeoutError:
+		return cliFail(EXIT_TIMEOUT, "%v", err)
+	case *ControllerError:
+		return cliFail(EXIT_CONTROLLER_ERROR, "%v", err)
+	}
+	return cliFail(EXIT_ERROR, "err=%v", err)
+}
+
+// printStatus prints the temperatures in the units of config.
+func printStatus(status Status, config *Config) {
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		fmt.Printf("Sensor %s: %d %s\n", sensorNames[sensor], status.Temperature(sensor), config.SensorUnit(sensor))
+	}
+	o := status.Outputs
+	fmt.Printf("Output 1: %d %%\nOutput 2: %d %%\nOutput 3: %d %%\nOutput 4: %d %%\n", o.Fan1, o.Fan2, o.Fan3, o.Fan4)
+	r := status.RPMS
+	fmt.Printf("Fan 1A: %d RPM\nFan 1B: %d RPM\nFan 2A: %d RPM\nFan 2B: %d RPM\n", r.Fan1A, r.Fan1B, r.Fan2A, r.Fan2B)
+	fmt.Printf("Fan 3A: %d RPM\nFan 3B: %d RPM\nFan 4A: %d RPM\nFan 4B: %d RPM\n", r.Fan3A, r.Fan3B, r.Fan4A, r.Fan4B)
+}
+
+func runStatus(args []string) int {
+	var appConfig AppConfig
+	readAppConfig(&appConfig)
+
+	flags, portName, timeout := newCLIFlags("status", &appConfig)
+	asJSON := flags.Bool("json", false, "print status as JSON")
+	parseArgs(flags, args)
+
+	client := newCLIClient(&appConfig)
+	if code := client.connect(*portName, *timeout); code != EXIT_OK {
+		return code
+	}
+	defer client.serial.StopRead()
+
+	status := client.serial.GetStatus()
+	if *asJSON {
+		fmt.Println(ToPrettyJSON(status))
+		return EXIT_OK
+	}
+	// the units of the sensors are in the config
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	config, err := client.serial.QueryConfig(ctx)
+	if err != nil {
+		return requestFail(err)
+	}
+	printStatus(status, &config)
+	return EXIT_OK
+}
+
+func runConfigGet(client *cliClient, timeout time.Duration) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	config, err := client.serial.QueryConfig(ctx)
+	if err != nil {
+		return requestFail(err)
+	}
+	if err := toml.NewEncoder(os.Stdout).Encode(config); err != nil {
+		return cliFail(EXIT_ERROR, "err=%v", err)
+	}
+	return EXIT_OK
+}
+
+func runConfigApply(client *cliClient, config *Config, timeout time.Duration) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := client.serial.Apply(ctx, config); err != nil {
+		return requestFail(err)
+	}
+	fmt.Fprintln(os.Stderr, "Config successfully applied")
+	return EXIT_OK
+}
+
+func runConfig(args []string) int {
+	var appConfig AppConfig
+	readAppConfig(&appConfig)
+
+	usage := "Usage: fancontroller config get | apply <file.toml> [flags]"
+	flags, portName, timeout := newCLIFlags("config", &appConfig)
+	positional := parseArgs(flags, args)
+	if len(positional) < 1 {
+		return cliFail(EXIT_ERROR, usage)
+	}
+
+	var config Config
+	switch positional[0] {
+	case "get":
+	case "apply":
+		if len(positional) != 2 {
+			return cliFail(EXIT_ERROR, usage)
+		}
+		if _, err := toml.DecodeFile(positional[1], &config); err != nil {
+			return cliFail(EXIT_ERROR, "err=%v", err)
+		}
+		if err := validateConfig(&config); err != nil {
+			return cliFail(EXIT_ERROR, "err=%v", err)
+		}
+	default:
+		return cliFail(EXIT_ERROR, usage)
+	}
+
+	client := newCLIClient(&appConfig)
+	if code := client.connect(*portName, *timeout); code != EXIT_OK {
+		return code
+	}
+	defer client.serial.StopRead()
+
+	if positional[0] == "get" {
+		return runConfigGet(client, *timeout)
+	}
+	return runConfigApply(client, &config, *timeout)
+}
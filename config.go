@@ -1,16 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"strconv"
 	"strings"
 )
 
 type Temperatures struct {
-	SensorA int8
-	SensorB int8
-	SensorC int8
-	SensorD int8
+	SensorA int16
+	SensorB int16
+	SensorC int16
+	SensorD int16
 }
 
 type Outputs struct {
@@ -37,6 +40,61 @@ type Status struct {
 	RPMS         RPMS
 }
 
+const SENSOR_COUNT = 4
+
+// range of temperatures in status frames, sensors in °F report -55 °C as -67
+// and MAX_TEMP as 302
+const (
+	MIN_SENSOR_TEMP = -67
+	MAX_SENSOR_TEMP = MAX_TEMP*9/5 + 32
+)
+
+// Temperature returns temperature of SENSOR_A - SENSOR_D.
+func (status *Status) Temperature(sensor int) int16 {
+	switch sensor {
+	case SENSOR_A:
+		return status.Temperatures.SensorA
+	case SENSOR_B:
+		return status.Temperatures.SensorB
+	case SENSOR_C:
+		return status.Temperatures.SensorC
+	case SENSOR_D:
+		return status.Temperatures.SensorD
+	}
+	return 0
+}
+
+// FanOutput returns output power of fan channel 1-4.
+func (status *Status) FanOutput(fan int) int8 {
+	switch fan {
+	case 1:
+		return status.Outputs.Fan1
+	case 2:
+		return status.Outputs.Fan2
+	case 3:
+		return status.Outputs.Fan3
+	case 4:
+		return status.Outputs.Fan4
+	}
+	return 0
+}
+
+// FanRPM returns speed of fans A and B of channel 1-4.
+func (status *Status) FanRPM(fan int) (int16, int16) {
+	r := &status.RPMS
+	switch fan {
+	case 1:
+		return r.Fan1A, r.Fan1B
+	case 2:
+		return r.Fan2A, r.Fan2B
+	case 3:
+		return r.Fan3A, r.Fan3B
+	case 4:
+		return r.Fan4A, r.Fan4B
+	}
+	return 0, 0
+}
+
 const (
 	SENSOR_NOT_CONNECTED = iota
 	SENSOR_TYPE_C
@@ -95,6 +153,62 @@ type Config struct {
 	Fan4Config  FanConfig
 }
 
+const FAN_COUNT = 4
+
+// FanConfig returns config of fan channel 1-4 or nil.
+func (config *Config) FanConfig(fan int) *FanConfig {
+	switch fan {
+	case 1:
+		return &config.Fan1Config
+	case 2:
+		return &config.Fan2Config
+	case 3:
+		return &config.Fan3Config
+	case 4:
+		return &config.Fan4Config
+	}
+	return nil
+}
+
+// SensorType returns type of SENSOR_A - SENSOR_D.
+func (config *Config) SensorType(sensor int) int8 {
+	switch sensor {
+	case SENSOR_A:
+		return config.SensorTypes.SensorTypeA
+	case SENSOR_B:
+		return config.SensorTypes.SensorTypeB
+	case SENSOR_C:
+		return config.SensorTypes.SensorTypeC
+	case SENSOR_D:
+		return config.SensorTypes.SensorTypeD
+	}
+	return SENSOR_NOT_CONNECTED
+}
+
+// SensorUnit returns the unit of the temperatures of SENSOR_A - SENSOR_D.
+func (config *Config) SensorUnit(sensor int) string {
+	if config.SensorType(sensor) == SENSOR_TYPE_F {
+		return "°F"
+	}
+	return "°C"
+}
+
+// ControlUnit returns the unit of the temperature range of a fan controlled
+// by control, a pair of sensors uses the unit of the first one.
+func (config *Config) ControlUnit(control int8) string {
+	switch {
+	case control >= SENSOR_A && control <= SENSOR_D:
+		return config.SensorUnit(int(control))
+	case control >= SENSOR_A_D && control <= SENSOR_C_D:
+		return config.SensorUnit(int(control - SENSOR_A_D))
+	}
+	return "°C"
+}
+
+func celsiusToFahrenheit(temp int) int {
+	return temp*9/5 + 32
+}
+
 type SuccessApply struct {
 }
 
@@ -103,116 +217,209 @@ type ErrorMessage struct {
 }
 
 func checkCommand(cmd, cmdName string) bool {
-	if cmd == cmdName || cmd == (string(0x0)+cmdName) {
+	if cmd == cmdName || cmd == ("\x00"+cmdName) {
 		return true
 	}
 	return false
 }
 
 func checkCommandPrefix(cmd, cmdName string) bool {
-	if strings.HasPrefix(cmd, cmdName) || strings.HasPrefix(cmd, (string(0x0)+cmdName)) {
+	if strings.HasPrefix(cmd, cmdName) || strings.HasPrefix(cmd, ("\x00"+cmdName)) {
 		return true
 	}
 	return false
 }
 
-func parseData(d []byte) interface{} {
-	sd := string(d)
-	ss := strings.Split(sd, "\r\n")
-	for _, ss := range ss {
-		rs := ""
-		if ss != string(0x0) {
-			rs += ss
+var ErrUnknownFrame = errors.New("Unknown frame")
+
+// FrameError rejects a frame with a malformed field, Field is the index
+// after the command name or 0 when the frame as a whole is invalid.
+type FrameError struct {
+	Command string
+	Field   int
+	Value   string
+	Reason  string
+}
+
+func (e *FrameError) Error() string {
+	if e.Field == 0 {
+		return fmt.Sprintf("Invalid %s frame: %s", e.Command, e.Reason)
+	}
+	return fmt.Sprintf("Invalid %s frame: field %d %q %s", e.Command, e.Field, e.Value, e.Reason)
+}
+
+// frameFields parses the numeric fields of a frame, after the first
+// malformed field err is set and all values are 0.
+type frameFields struct {
+	sp  []string
+	err error
+}
+
+func (f *frameFields) field(i int, min, max int64) int64 {
+	if f.err != nil {
+		return 0
+	}
+	command := strings.TrimPrefix(f.sp[0], "\x00")
+	v, err := strconv.ParseInt(f.sp[i], 10, 64)
+	if err != nil {
+		f.err = &FrameError{Command: command, Field: i, Value: f.sp[i], Reason: "isn't a number"}
+		return 0
+	}
+	if v < min || v > max {
+		f.err = &FrameError{Command: command, Field: i, Value: f.sp[i], Reason: fmt.Sprintf("is out of range %d-%d", min, max)}
+		return 0
+	}
+	return v
+}
+
+func (f *frameFields) int8(i int, min, max int64) int8 {
+	return int8(f.field(i, min, max))
+}
+
+func (f *frameFields) int16(i int, min, max int64) int16 {
+	return int16(f.field(i, min, max))
+}
+
+func (f *frameFields) bool(i int) bool {
+	return f.field(i, 0, 1) == 1
+}
+
+// parseData decodes one frame, frames with a non-numeric or out of range
+// field are rejected with FrameError.
+func parseData(d []byte) (interface{}, error) {
+	for _, rs := range strings.Split(string(d), "\r\n") {
+		if rs == "" || rs == "\x00" {
+			continue
 		}
 		sp := strings.Split(rs, ",")
-		if len(sp) > 0 {
-			if checkCommand(sp[0], "FCD") && len(sp) == 17 {
-				status := &Status{
-					Temperatures: Temperatures{
-						SensorA: StrToInt8(sp[1]),
-						SensorB: StrToInt8(sp[2]),
-						SensorC: StrToInt8(sp[3]),
-						SensorD: StrToInt8(sp[4]),
-					},
-					Outputs: Outputs{
-						Fan1: StrToInt8(sp[5]),
-						Fan2: StrToInt8(sp[6]),
-						Fan3: StrToInt8(sp[7]),
-						Fan4: StrToInt8(sp[8]),
-					},
-					RPMS: RPMS{
-						Fan1A: StrToInt16(sp[9]),
-						Fan1B: StrToInt16(sp[10]),
-						Fan2A: StrToInt16(sp[11]),
-						Fan2B: StrToInt16(sp[12]),
-						Fan3A: StrToInt16(sp[13]),
-						Fan3B: StrToInt16(sp[14]),
-						Fan4A: StrToInt16(sp[15]),
-						Fan4B: StrToInt16(sp[16]),
-					},
-				}
-				if DEBUG_INFO {
-					log.Printf("status=%s", ToJSON(status))
-				}
-				return status
-			} else if checkCommand(sp[0], "FCR") && len(sp) == 33 {
-				config := &Config{
-					SensorTypes: SensorTypes{
-						SensorTypeA: StrToInt8(sp[1]),
-						SensorTypeB: StrToInt8(sp[2]),
-						SensorTypeC: StrToInt8(sp[3]),
-						SensorTypeD: StrToInt8(sp[4]),
-					},
-					Fan1Config: FanConfig{
-						MinimumPower:       StrToInt8(sp[5]),
-						SensorControlling:  StrToInt8(sp[6]),
-						MinimumTemperature: StrToInt16(sp[7]),
-						MaximumTemperature: StrToInt16(sp[8]),
-						AllowStopped:       Int8ToBool(StrToInt8(sp[9])),
-						FanTypeA:           StrToInt8(sp[10]),
-						FanTypeB:           StrToInt8(sp[11]),
-					},
-					Fan2Config: FanConfig{
-						MinimumPower:       StrToInt8(sp[12]),
-						SensorControlling:  StrToInt8(sp[13]),
-						MinimumTemperature: StrToInt16(sp[14]),
-						MaximumTemperature: StrToInt16(sp[15]),
-						AllowStopped:       Int8ToBool(StrToInt8(sp[16])),
-						FanTypeA:           StrToInt8(sp[17]),
-						FanTypeB:           StrToInt8(sp[18]),
-					},
-					Fan3Config: FanConfig{
-						MinimumPower:       StrToInt8(sp[19]),
-						SensorControlling:  StrToInt8(sp[20]),
-						MinimumTemperature: StrToInt16(sp[21]),
-						MaximumTemperature: StrToInt16(sp[22]),
-						AllowStopped:       Int8ToBool(StrToInt8(sp[23])),
-						FanTypeA:           StrToInt8(sp[24]),
-						FanTypeB:           StrToInt8(sp[25]),
-					},
-					Fan4Config: FanConfig{
-						MinimumPower:       StrToInt8(sp[26]),
-						SensorControlling:  StrToInt8(sp[27]),
-						MinimumTemperature: StrToInt16(sp[28]),
-						MaximumTemperature: StrToInt16(sp[29]),
-						AllowStopped:       Int8ToBool(StrToInt8(sp[30])),
-						FanTypeA:           StrToInt8(sp[31]),
-						FanTypeB:           StrToInt8(sp[32]),
-					},
-				}
-				if DEBUG_INFO {
-					log.Printf("config=%s", ToJSON(config))
-				}
-				return config
-			} else if checkCommand(sp[0], "FCA") && len(sp) == 1 {
-				return SuccessApply{}
-			} else if checkCommandPrefix(sp[0], "ERR") && len(sp) == 1 {
-				errMsg := strings.TrimPrefix(sp[0], "ERR:")
-				if DEBUG_INFO {
-					log.Printf("errMsg=%q", errMsg)
+		switch {
+		case checkCommand(sp[0], "FCD") && len(sp) == 17:
+			status, err := parseStatusFields(sp)
+			if err != nil {
+				return nil, err
+			}
+			if DEBUG_INFO {
+				log.Printf("status=%s", ToJSON(status))
+			}
+			return status, nil
+		case checkCommand(sp[0], "FCR") && len(sp) == 33:
+			config, err := parseConfigFields(sp)
+			if err == nil {
+				if err = validateConfig(config); err != nil {
+					err = &FrameError{Command: "FCR", Reason: err.Error()}
 				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if DEBUG_INFO {
+				log.Printf("config=%s", ToJSON(config))
+			}
+			return config, nil
+		case checkCommand(sp[0], "FCD"), checkCommand(sp[0], "FCR"):
+			return nil, &FrameError{Command: strings.TrimPrefix(sp[0], "\x00"), Reason: fmt.Sprintf("%d fields", len(sp)-1)}
+		case checkCommand(sp[0], "FCA") && len(sp) == 1:
+			return SuccessApply{}, nil
+		case checkCommandPrefix(sp[0], "ERR") && len(sp) == 1:
+			errMsg := strings.TrimPrefix(strings.TrimPrefix(sp[0], "\x00"), "ERR:")
+			if DEBUG_INFO {
+				log.Printf("errMsg=%q", errMsg)
+			}
+			return ErrorMessage{Message: errMsg}, nil
+		}
+		return nil, ErrUnknownFrame
+	}
+	return nil, ErrUnknownFrame
+}
+
+// parseStatusFields builds Status from the 16 values following FCD.
+func parseStatusFields(sp []string) (*Status, error) {
+	f := frameFields{sp: sp}
+	status := &Status{
+		Temperatures: Temperatures{
+			SensorA: f.int16(1, MIN_SENSOR_TEMP, MAX_SENSOR_TEMP),
+			SensorB: f.int16(2, MIN_SENSOR_TEMP, MAX_SENSOR_TEMP),
+			SensorC: f.int16(3, MIN_SENSOR_TEMP, MAX_SENSOR_TEMP),
+			SensorD: f.int16(4, MIN_SENSOR_TEMP, MAX_SENSOR_TEMP),
+		},
+		Outputs: Outputs{
+			Fan1: f.int8(5, 0, 100),
+			Fan2: f.int8(6, 0, 100),
+			Fan3: f.int8(7, 0, 100),
+			Fan4: f.int8(8, 0, 100),
+		},
+		RPMS: RPMS{
+			Fan1A: f.int16(9, 0, math.MaxInt16),
+			Fan1B: f.int16(10, 0, math.MaxInt16),
+			Fan2A: f.int16(11, 0, math.MaxInt16),
+			Fan2B: f.int16(12, 0, math.MaxInt16),
+			Fan3A: f.int16(13, 0, math.MaxInt16),
+			Fan3B: f.int16(14, 0, math.MaxInt16),
+			Fan4A: f.int16(15, 0, math.MaxInt16),
+			Fan4B: f.int16(16, 0, math.MaxInt16),
+		},
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return status, nil
+}
 
-				return ErrorMessage{Message: errMsg}
+// parseConfigFields builds Config from the 32 values following the command
+// name of FCR and FCS frames. Only the field types are checked, the values
+// are left to validateConfig.
+func parseConfigFields(sp []string) (*Config, error) {
+	f := frameFields{sp: sp}
+	fan := func(i int) FanConfig {
+		return FanConfig{
+			MinimumPower:       f.int8(i, math.MinInt8, math.MaxInt8),
+			SensorControlling:  f.int8(i+1, math.MinInt8, math.MaxInt8),
+			MinimumTemperature: f.int16(i+2, math.MinInt16, math.MaxInt16),
+			MaximumTemperature: f.int16(i+3, math.MinInt16, math.MaxInt16),
+			AllowStopped:       f.bool(i + 4),
+			FanTypeA:           f.int8(i+5, math.MinInt8, math.MaxInt8),
+			FanTypeB:           f.int8(i+6, math.MinInt8, math.MaxInt8),
+		}
+	}
+	config := &Config{
+		SensorTypes: SensorTypes{
+			SensorTypeA: f.int8(1, math.MinInt8, math.MaxInt8),
+			SensorTypeB: f.int8(2, math.MinInt8, math.MaxInt8),
+			SensorTypeC: f.int8(3, math.MinInt8, math.MaxInt8),
+			SensorTypeD: f.int8(4, math.MinInt8, math.MaxInt8),
+		},
+		Fan1Config: fan(5),
+		Fan2Config: fan(12),
+		Fan3Config: fan(19),
+		Fan4Config: fan(26),
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return config, nil
+}
+
+func validateConfig(config *Config) error {
+	st := config.SensorTypes
+	for _, t := range []int8{st.SensorTypeA, st.SensorTypeB, st.SensorTypeC, st.SensorTypeD} {
+		if t < SENSOR_NOT_CONNECTED || t > SENSOR_TYPE_F {
+			return fmt.Errorf("Invalid sensor type %d", t)
+		}
+	}
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		fc := config.FanConfig(fan)
+		if fc.MinimumPower < 0 || fc.MinimumPower > 100 {
+			return fmt.Errorf("Invalid minimum power %d of fan %d", fc.MinimumPower, fan)
+		}
+		if fc.SensorControlling < SENSOR_A || fc.SensorControlling > MANUAL_CONTROL {
+			return fmt.Errorf("Invalid control %d of fan %d", fc.SensorControlling, fan)
+		}
+		if fc.MinimumTemperature < 0 || fc.MaximumTemperature > MAX_SENSOR_TEMP || fc.MinimumTemperature > fc.MaximumTemperature {
+			return fmt.Errorf("Invalid temperature range %d-%d of fan %d", fc.MinimumTemperature, fc.MaximumTemperature, fan)
+		}
+		for _, t := range []int8{fc.FanTypeA, fc.FanTypeB} {
+			if t < FAN_NOT_CONNECTED || t > FAN_4_WIRE {
+				return fmt.Errorf("Invalid fan type %d of fan %d", t, fan)
 			}
 		}
 	}
@@ -220,7 +427,11 @@ func parseData(d []byte) interface{} {
 }
 
 func configToStr(config *Config) string {
-	return fmt.Sprintf("FCS,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d",
+	return "FCS," + configValuesToStr(config)
+}
+
+func configValuesToStr(config *Config) string {
+	return fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d",
 		config.SensorTypes.SensorTypeA, config.SensorTypes.SensorTypeB, config.SensorTypes.SensorTypeC, config.SensorTypes.SensorTypeD,
 		config.Fan1Config.MinimumPower, config.Fan1Config.SensorControlling, config.Fan1Config.MinimumTemperature, config.Fan1Config.MaximumTemperature,
 		BoolToInt(config.Fan1Config.AllowStopped), config.Fan1Config.FanTypeA, config.Fan1Config.FanTypeB,
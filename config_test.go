@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var testConfig = Config{
+	SensorTypes: SensorTypes{SensorTypeA: SENSOR_TYPE_C, SensorTypeB: SENSOR_TYPE_F, SensorTypeC: SENSOR_NOT_CONNECTED, SensorTypeD: SENSOR_TYPE_C},
+	Fan1Config:  FanConfig{MinimumPower: 30, SensorControlling: SENSOR_A, MinimumTemperature: 30, MaximumTemperature: 50, FanTypeA: FAN_4_WIRE},
+	Fan2Config:  FanConfig{MinimumPower: 20, SensorControlling: SENSOR_B, MinimumTemperature: 80, MaximumTemperature: 120, AllowStopped: true, FanTypeA: FAN_3_WIRE_X1_TACHO},
+	Fan3Config:  FanConfig{MinimumPower: 50, SensorControlling: MANUAL_CONTROL, MinimumTemperature: 30, MaximumTemperature: 50, FanTypeA: FAN_2_WIRE},
+	Fan4Config:  FanConfig{MinimumPower: 30, SensorControlling: SENSOR_A_D, MinimumTemperature: 30, MaximumTemperature: 50},
+}
+
+// withField replaces field i of the comma separated frame.
+func withField(frame string, i int, value string) string {
+	sp := strings.Split(frame, ",")
+	sp[i] = value
+	return strings.Join(sp, ",")
+}
+
+func TestParseData(t *testing.T) {
+	status := "FCD,25,77,0,26,30,0,50,30,593,0,0,0,0,0,0,0"
+	config := "FCR," + configValuesToStr(&testConfig)
+	tests := []struct {
+		frame string
+		want  interface{}
+		field int
+	}{
+		{status, &Status{}, 0},
+		{"\x00" + status, &Status{}, 0},
+		{withField(status, 2, "x6"), nil, 2},
+		{withField(status, 2, "303"), nil, 2},
+		{withField(status, 2, "-68"), nil, 2},
+		{withField(status, 2, "302"), &Status{}, 0},
+		{withField(status, 5, "101"), nil, 5},
+		{withField(status, 9, "-1"), nil, 9},
+		{"FCD,25,26", nil, 0},
+		{status + ",0", nil, 0},
+		{config, &Config{}, 0},
+		{withField(config, 9, "2"), nil, 9},
+		{withField(config, 5, "x"), nil, 5},
+		{withField(config, 7, "60"), nil, 0},
+		{"FCR,1,1", nil, 0},
+		{"FCA", SuccessApply{}, 0},
+		{"ERR:Invalid config", ErrorMessage{Message: "Invalid config"}, 0},
+	}
+	for _, test := range tests {
+		v, err := parseData([]byte(test.frame))
+		if test.want == nil {
+			fe, ok := err.(*FrameError)
+			if v != nil || !ok || fe.Field != test.field {
+				t.Errorf("%q: v=%v err=%v, want FrameError of field %d", test.frame, v, err, test.field)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: err=%v", test.frame, err)
+			continue
+		}
+		switch want := test.want.(type) {
+		case *Status:
+			if _, ok := v.(*Status); !ok {
+				t.Errorf("%q: got %T", test.frame, v)
+			}
+		case *Config:
+			if c, ok := v.(*Config); !ok || *c != testConfig {
+				t.Errorf("%q: got %+v", test.frame, v)
+			}
+		default:
+			if v != want {
+				t.Errorf("%q: got %+v, want %+v", test.frame, v, want)
+			}
+		}
+	}
+
+	if _, err := parseData([]byte("XYZ,1")); err != ErrUnknownFrame {
+		t.Errorf("unknown frame err=%v", err)
+	}
+}
+
+func TestDecodeCountsMalformedFrames(t *testing.T) {
+	ser := NewSerial(&AppConfig{})
+	for _, frame := range []string{"FCD,25,26", "FCD,x,26,0,77,30,0,50,30,593,0,0,0,0,0,0,0", "XYZ", "FCA"} {
+		ser.decode([]byte(frame))
+	}
+	stats := ser.GetStats()
+	if stats.Frames != 4 || stats.ParseFailures != 3 || stats.MalformedFrames != 2 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestValidateConfigTemperatureRange(t *testing.T) {
+	tests := []struct {
+		min, max int16
+		valid    bool
+	}{
+		{30, 50, true},
+		{0, MAX_TEMP, true},
+		{100, 200, true},
+		{0, MAX_SENSOR_TEMP, true},
+		{0, MAX_SENSOR_TEMP + 1, false},
+		{-1, 50, false},
+		{60, 50, false},
+	}
+	for _, test := range tests {
+		config := testConfig
+		config.Fan2Config.MinimumTemperature = test.min
+		config.Fan2Config.MaximumTemperature = test.max
+		if err := validateConfig(&config); (err == nil) != test.valid {
+			t.Errorf("range %d-%d err=%v", test.min, test.max, err)
+		}
+	}
+}
+
+func TestControlUnit(t *testing.T) {
+	tests := []struct {
+		control int8
+		unit    string
+	}{
+		{SENSOR_A, "°C"},
+		{SENSOR_B, "°F"},
+		{SENSOR_B_D, "°F"},
+		{SENSOR_C_D, "°C"},
+		{MANUAL_CONTROL, "°C"},
+	}
+	for _, test := range tests {
+		if unit := testConfig.ControlUnit(test.control); unit != test.unit {
+			t.Errorf("control %d unit %s, want %s", test.control, unit, test.unit)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FAN_CURVE_INTERVAL        = 2
+	FAN_CURVE_MIN_STEP        = 2
+	FAN_CURVE_RELEASE_TIMEOUT = time.Second * 2
+)
+
+// FanCurve sets the power of Fan from the temperature of Sensor, or the
+// highest of Sensors, by linear interpolation between Points, each point is
+// [temperature, power %]. Sensors are A-D or names of host sensors.
+type FanCurve struct {
+	Fan     int
+	Sensor  string
+	Sensors []string
+	Points  [][2]int
+}
+
+func (curve *FanCurve) sensors() []string {
+	if curve.Sensor != "" {
+		return append([]string{curve.Sensor}, curve.Sensors...)
+	}
+	return curve.Sensors
+}
+
+func (curve *FanCurve) validate(hostNames []string) error {
+	if curve.Fan < 1 || curve.Fan > FAN_COUNT {
+		return fmt.Errorf("Invalid fan %d of fan curve", curve.Fan)
+	}
+	if len(curve.sensors()) == 0 {
+		return fmt.Errorf("Fan curve %d has no sensor", curve.Fan)
+	}
+	for _, name := range curve.sensors() {
+		if !validSensor(name, hostNames) {
+			return fmt.Errorf("Invalid sensor %q of fan curve %d", name, curve.Fan)
+		}
+	}
+	if len(curve.Points) == 0 {
+		return fmt.Errorf("Fan curve %d has no points", curve.Fan)
+	}
+	for i, p := range curve.Points {
+		if p[0] < 0 || p[0] > MAX_TEMP || p[1] < 0 || p[1] > 100 {
+			return fmt.Errorf("Invalid point %v of fan curve %d", p, curve.Fan)
+		}
+		if i > 0 && p[0] <= curve.Points[i-1][0] {
+			return fmt.Errorf("Temperatures of fan curve %d must increase", curve.Fan)
+		}
+	}
+	return nil
+}
+
+// validSensor reports whether name is one of A-D or of the host sensors.
+func validSensor(name string, hostNames []string) bool {
+	if sensorIndex(name) >= 0 {
+		return true
+	}
+	for _, v := range hostNames {
+		if strings.EqualFold(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (curve *FanCurve) power(temp int) int {
+	points := curve.Points
+	if temp <= points[0][0] {
+		return points[0][1]
+	}
+	for i := 1; i < len(points); i++ {
+		if temp <= points[i][0] {
+			t0, p0 := points[i-1][0], points[i-1][1]
+			t1, p1 := points[i][0], points[i][1]
+			return p0 + (p1-p0)*(temp-t0)/(t1-t0)
+		}
+	}
+	return points[len(points)-1][1]
+}
+
+// FanCurves drives fans in MANUAL_CONTROL by sending the power of their
+// AppConfig.FanCurves or AppConfig.FanPIDs, writes are sent at most every
+// FanCurveInterval seconds. When the application exits or the connection
+// ends the fallback config is sent, it's the profile FanCurveFallback, the linear
+// ramp of the firmware closest to the curve or PID target, or the last power
+// of the curve (MaxPower of the PID) when it follows a host sensor. The
+// curves take over again after reconnecting.
+type FanCurves struct {
+	serial      *Serial
+	host        *HostSensors
+	curves      [FAN_COUNT]*FanCurve
+	pids        [FAN_COUNT]*FanPID
+	interval    time.Duration
+	pidInterval time.Duration
+	fallback    string
+
+	lock      sync.Mutex
+	config    Config
+	pidStates [FAN_COUNT]pidState
+	connected bool
+	resume    bool
+	applying  bool
+	suspended bool
+	last      time.Time
+}
+
+func NewFanCurves(serial *Serial, host *HostSensors, appConfig *AppConfig) *FanCurves {
+	fc := &FanCurves{
+		serial:   serial,
+		host:     host,
+		interval: time.Duration(appConfig.FanCurveInterval) * time.Second,
+		fallback: appConfig.FanCurveFallback,
+
+		pidInterval: time.Duration(appConfig.FanPIDInterval * float64(time.Second)),
+	}
+	for i := range appConfig.FanCurves {
+		curve := &appConfig.FanCurves[i]
+		if err := curve.validate(host.Names()); err != nil {
+			log.Printf("err=%v", err)
+			continue
+		}
+		fc.curves[curve.Fan-1] = curve
+	}
+	for i := range appConfig.FanPIDs {
+		pid := &appConfig.FanPIDs[i]
+		if err := pid.validate(host.Names(), appConfig.MaxTemp); err != nil {
+			log.Printf("err=%v", err)
+			continue
+		}
+		if fc.curves[pid.Fan-1] != nil {
+			log.Printf("Fan %d has a curve and a PID, the PID is ignored", pid.Fan)
+			continue
+		}
+		fc.pids[pid.Fan-1] = pid
+	}
+	return fc
+}
+
+func (fc *FanCurves) controlled(fan int) bool {
+	return fc.curves[fan-1] != nil || fc.pids[fan-1] != nil
+}
+
+func (fc *FanCurves) enabled() bool {
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		if fc.controlled(fan) {
+			return true
+		}
+	}
+	return false
+}
+
+// PID returns the PID of fan 1-4 or nil.
+func (fc *FanCurves) PID(fan int) *FanPID {
+	return fc.pids[fan-1]
+}
+
+// PIDSamples returns the recent updates of the PID of fan 1-4.
+func (fc *FanCurves) PIDSamples(fan int) []PIDSample {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return append([]PIDSample(nil), fc.pidStates[fan-1].samples...)
+}
+
+// fallbackConfig returns config with the fallback settings of the fans with
+// a curve.
+func (fc *FanCurves) fallbackConfig(config Config) Config {
+	var profile *Config
+	if fc.fallback != "" {
+		if c, err := loadScheduleTarget(fc.fallback); err != nil {
+			log.Printf("Fan curve fallback err=%v", err)
+		} else {
+			profile = &c
+		}
+	}
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		if !fc.controlled(fan) {
+			continue
+		}
+		f := config.FanConfig(fan)
+		if profile != nil {
+			*f = *profile.FanConfig(fan)
+			continue
+		}
+		if pid := fc.pids[fan-1]; pid != nil {
+			f.AllowStopped = false
+			f.SensorControlling = MANUAL_CONTROL
+			f.MinimumPower = int8(pid.MaxPower)
+			if sensor := sensorIndex(pid.Sensor); sensor >= 0 {
+				f.SensorControlling = int8(sensor)
+				f.MinimumPower = int8(pid.MinPower)
+				f.MinimumTemperature = int16(pid.Target) - FAN_PID_FALLBACK_BAND
+				f.MaximumTemperature = int16(pid.Target) + FAN_PID_FALLBACK_BAND
+				if f.MinimumTemperature < 0 {
+					f.MinimumTemperature = 0
+				}
+				if f.MaximumTemperature > MAX_SENSOR_TEMP {
+					f.MaximumTemperature = MAX_SENSOR_TEMP
+				}
+			}
+			continue
+		}
+		curve := fc.curves[fan-1]
+		first, last := curve.Points[0], curve.Points[len(curve.Points)-1]
+		f.AllowStopped = false
+		f.SensorControlling = MANUAL_CONTROL
+		f.MinimumPower = int8(last[1])
+		for _, name := range curve.sensors() {
+			if sensor := sensorIndex(name); sensor >= 0 {
+				f.SensorControlling = int8(sensor)
+				f.MinimumPower = int8(first[1])
+				f.MinimumTemperature = int16(first[0])
+				f.MaximumTemperature = int16(last[0])
+				break
+			}
+		}
+	}
+	return config
+}
+
+// temperature returns the highest temperature of the sensors which could be
+// read. Host sensors read °C, they're converted to °F when a controller
+// sensor of sensors is in °F as the curve is in the unit of the controller.
+func (fc *FanCurves) temperature(sensors []string, status *Status) (int, bool) {
+	fahrenheit := false
+	for _, name := range sensors {
+		if sensor := sensorIndex(name); sensor >= 0 && fc.config.SensorType(sensor) == SENSOR_TYPE_F {
+			fahrenheit = true
+		}
+	}
+
+	max, ok := 0, false
+	for _, name := range sensors {
+		var temp int
+		if sensor := sensorIndex(name); sensor >= 0 {
+			if fc.config.SensorType(sensor) == SENSOR_NOT_CONNECTED {
+				continue
+			}
+			temp = int(status.Temperature(sensor))
+		} else if t, valid := fc.host.Temperature(name); valid {
+			temp = t
+			if fahrenheit {
+				temp = celsiusToFahrenheit(t)
+			}
+		} else {
+			continue
+		}
+		if !ok || temp > max {
+			max, ok = temp, true
+		}
+	}
+	return max, ok
+}
+
+func (fc *FanCurves) OnEvent(ev Event) {
+	switch ev := ev.(type) {
+	case Connected:
+		fc.OnConnect(ev.Port)
+	case StatusReceived:
+		fc.OnStatus(ev.Status)
+	case ConfigReceived:
+		fc.OnConfig(ev.Config)
+	case Disconnected, Closed:
+		fc.lock.Lock()
+		fc.connected = false
+		fc.lock.Unlock()
+	case TempEmergency:
+		fc.suspend(ev.Active)
+	}
+}
+
+// suspend stops writing the fans during a temperature emergency, the PID
+// loops start over afterwards.
+func (fc *FanCurves) suspend(active bool) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if fc.suspended == active {
+		return
+	}
+	fc.suspended = active
+	if active {
+		log.Printf("Fan curves suspended during temperature emergency")
+	}
+	for i := range fc.pidStates {
+		fc.pidStates[i].started = false
+	}
+}
+
+func (fc *FanCurves) OnConnect(portName string) {
+	fc.lock.Lock()
+	fc.connected = true
+	fc.resume = true
+	for i := range fc.pidStates {
+		fc.pidStates[i].started = false
+	}
+	fc.lock.Unlock()
+}
+
+func (fc *FanCurves) OnStatus(status Status) {
+	if !fc.enabled() {
+		return
+	}
+	now := time.Now()
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if !fc.connected || fc.resume || fc.suspended {
+		return
+	}
+
+	// PID loops run at their own rate, only the writes are limited
+	for fan, pid := range fc.pids {
+		f := fc.config.FanConfig(fan + 1)
+		st := &fc.pidStates[fan]
+		if pid == nil || f.SensorControlling != MANUAL_CONTROL {
+			st.started = false
+			continue
+		}
+		if st.started && now.Sub(st.last) < fc.pidInterval {
+			continue
+		}
+		if temp, ok := fc.temperature([]string{pid.Sensor}, &status); ok {
+			pid.update(st, float64(temp), float64(f.MinimumPower), now)
+		}
+	}
+
+	if fc.applying || now.Sub(fc.last) < fc.interval {
+		return
+	}
+	fc.last = now
+
+	config := fc.config
+	changed := false
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		f := config.FanConfig(fan)
+		if !fc.controlled(fan) || f.SensorControlling != MANUAL_CONTROL {
+			continue
+		}
+		var power int
+		if curve := fc.curves[fan-1]; curve != nil {
+			temp, ok := fc.temperature(curve.sensors(), &status)
+			if !ok {
+				continue
+			}
+			power = curve.power(temp)
+		} else if st := &fc.pidStates[fan-1]; st.started {
+			power = int(st.output + 0.5)
+		} else {
+			continue
+		}
+		diff := power - int(f.MinimumPower)
+		if diff >= FAN_CURVE_MIN_STEP || diff <= -FAN_CURVE_MIN_STEP || (diff != 0 && (power == 0 || power == 100)) {
+			f.MinimumPower = int8(power)
+			changed = true
+		}
+	}
+	if changed {
+		fc.apply(config)
+	}
+}
+
+// apply sends config without blocking the event dispatch.
+func (fc *FanCurves) apply(config Config) {
+	fc.applying = true
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), APPLY_TIMEOUT)
+		defer cancel()
+		if err := fc.serial.Apply(ctx, &config); err != nil {
+			log.Printf("Fan curve couldn't apply config err=%v", err)
+		}
+		fc.lock.Lock()
+		fc.applying = false
+		fc.lock.Unlock()
+	}()
+}
+
+func (fc *FanCurves) OnConfig(config Config) {
+	if !fc.enabled() {
+		return
+	}
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.config = config
+	if !fc.resume {
+		return
+	}
+	fc.resume = false
+
+	// take over the fans which are still on the fallback we sent
+	fallback := fc.fallbackConfig(config)
+	changed := false
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		f := config.FanConfig(fan)
+		if fc.controlled(fan) && f.SensorControlling != MANUAL_CONTROL && *f == *fallback.FanConfig(fan) {
+			f.SensorControlling = MANUAL_CONTROL
+			changed = true
+		}
+	}
+	if changed {
+		log.Printf("Fan curves take over the fans from the fallback config")
+		fc.apply(config)
+	}
+}
+
+// Release sends the fallback config and waits for the reply, it's called
+// before the application closes the link or exits.
+func (fc *FanCurves) Release() {
+	fallback := fc.release()
+	if fallback == nil {
+		return
+	}
+	log.Printf("Sending fan curve fallback config")
+	ctx, cancel := context.WithTimeout(context.Background(), FAN_CURVE_RELEASE_TIMEOUT)
+	defer cancel()
+	if err := fc.serial.Apply(ctx, fallback); err != nil {
+		log.Printf("Fan curve fallback err=%v", err)
+	}
+}
+
+// release stops driving the fans and returns the fallback config, nil when
+// no curve fan is in MANUAL_CONTROL. It's registered with Serial.SetFallback
+// so the fallback is written before the port of a lost link is closed.
+func (fc *FanCurves) release() *Config {
+	if !fc.enabled() {
+		return nil
+	}
+	fc.lock.Lock()
+	connected := fc.connected
+	fc.connected = false
+	config := fc.config
+	fc.lock.Unlock()
+	if !connected {
+		return nil
+	}
+
+	manual := false
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		if fc.controlled(fan) && config.FanConfig(fan).SensorControlling == MANUAL_CONTROL {
+			manual = true
+		}
+	}
+	if !manual {
+		return nil
+	}
+
+	fallback := fc.fallbackConfig(config)
+	if DEBUG_INFO {
+		log.Printf("Fan curve fallback config=%s", configToStr(&fallback))
+	}
+	return &fallback
+}
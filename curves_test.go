@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFanCurvesFallbackOnLinkLoss(t *testing.T) {
+	appConfig := &AppConfig{
+		FanCurveInterval: FAN_CURVE_INTERVAL,
+		FanCurves:        []FanCurve{{Fan: 3, Sensor: "A", Points: [][2]int{{20, 30}, {60, 100}}}},
+	}
+	a, b := NewMemTransportPair()
+	em := NewEmulator()
+	if _, err := b.Write([]byte(em.statusStr() + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	ser := NewSerial(appConfig)
+	fc := NewFanCurves(ser, NewHostSensors(appConfig), appConfig)
+	ser.Subscribe(fc.OnEvent)
+	ser.SetFallback(fc.release)
+	if err := ser.ConnectTransport(a, "mem"); err != nil {
+		t.Fatalf("connect err=%v", err)
+	}
+	t.Cleanup(ser.StopRead)
+
+	if _, err := b.Write([]byte(em.handleCommand("FCQ") + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(TEST_TIMEOUT)
+	for {
+		fc.lock.Lock()
+		manual := fc.config.Fan3Config.SensorControlling == MANUAL_CONTROL
+		fc.lock.Unlock()
+		if manual {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fan curves didn't get the config")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	fallback := fc.fallbackConfig(em.config)
+	if fallback.Fan3Config.SensorControlling != SENSOR_A {
+		t.Fatalf("fallback fan 3 %+v", fallback.Fan3Config)
+	}
+	ser.Drop(errors.New("link lost"))
+	framer := NewFramer()
+	buf := make([]byte, 256)
+	for {
+		n, err := b.Read(buf)
+		if err != nil {
+			t.Fatalf("no fallback before close err=%v", err)
+		}
+		for _, frame := range framer.Feed(buf[:n]) {
+			if string(frame) == configToStr(&fallback) {
+				return
+			}
+		}
+	}
+}
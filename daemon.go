@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	DAEMON_RETRY_INTERVAL = time.Second * 5
+)
+
+// Daemon keeps the connection to the controller without any GUI, status and
+// config are kept in memory by Serial.
+type Daemon struct {
+	serial    *Serial
+	scheduler *Scheduler
+	api       *APIServer
+	mqtt      *MQTTBridge
+	history   *History
+	alerts    *Alerts
+	curves    *FanCurves
+	appConfig *AppConfig
+
+	lost chan error
+}
+
+func NewDaemon(appConfig *AppConfig) *Daemon {
+	daemon := &Daemon{
+		serial:    NewSerial(appConfig),
+		appConfig: appConfig,
+		lost:      make(chan error, 1),
+	}
+	daemon.serial.Subscribe(daemon.OnEvent)
+	daemon.scheduler = NewScheduler(daemon.serial, appConfig)
+	daemon.serial.Subscribe(daemon.scheduler.OnEvent)
+	daemon.api = NewAPIServer(daemon.serial, appConfig)
+	daemon.mqtt = NewMQTTBridge(daemon.serial, appConfig)
+	daemon.serial.Subscribe(daemon.mqtt.OnEvent)
+	daemon.history = NewHistory(appConfig)
+	daemon.serial.Subscribe(daemon.history.OnEvent)
+	daemon.alerts = NewAlerts(appConfig)
+	daemon.serial.Subscribe(NewFanMonitor(daemon.alerts).OnEvent)
+	daemon.serial.Subscribe(NewTempAlarmMonitor(daemon.serial, daemon.alerts, appConfig).OnEvent)
+	daemon.serial.Subscribe(NewHooks(appConfig).OnEvent)
+	daemon.serial.Subscribe(NewWatchdog(daemon.serial, daemon.alerts, appConfig).OnEvent)
+	daemon.curves = NewFanCurves(daemon.serial, NewHostSensors(appConfig), appConfig)
+	daemon.serial.Subscribe(daemon.curves.OnEvent)
+	daemon.serial.SetFallback(daemon.curves.release)
+	return daemon
+}
+
+func (d *Daemon) OnEvent(ev Event) {
+	switch ev := ev.(type) {
+	case StatusReceived:
+		if DEBUG_INFO {
+			log.Printf("status=%s", ToJSON(ev.Status))
+		}
+	case ConfigReceived:
+		log.Printf("config=%s", ToJSON(ev.Config))
+	case ApplySucceeded:
+		log.Printf("Config successfully applied")
+	case ApplyFailed:
+		log.Printf("Config rejected err=%s", ev.Message)
+	case LinkError:
+		log.Printf("err=%v", ev.Err)
+	case Disconnected:
+		select {
+		case d.lost <- ev.Err:
+		default:
+		}
+	}
+}
+
+// Run connects to portName and keeps reconnecting after errors until stop is
+// closed, "auto" scans for the controller before each attempt.
+func (d *Daemon) Run(portName string, retry time.Duration, stop <-chan struct{}) {
+	d.scheduler.Start()
+	defer d.scheduler.Stop()
+	d.api.Start()
+	defer d.api.Stop()
+	d.mqtt.Start()
+	defer d.mqtt.Stop()
+	defer d.history.Close()
+
+	for {
+		select {
+		case <-d.lost:
+		default:
+		}
+		port, err := resolvePort(portName)
+		if err == nil {
+			err = d.serial.ConnectToController(port)
+		}
+		if err == nil {
+			log.Printf("Connected to %s", port)
+			select {
+			case err = <-d.lost:
+				log.Printf("Connection lost err=%v", err)
+			case <-stop:
+				d.curves.Release()
+				d.serial.StopRead()
+				return
+			}
+			d.serial.StopRead()
+		} else {
+			log.Printf("Couldn't connect to %s err=%v", portName, err)
+		}
+
+		select {
+		case <-time.After(retry):
+		case <-stop:
+			return
+		}
+	}
+}
+
+func runDaemon(args []string) {
+	var appConfig AppConfig
+	readAppConfig(&appConfig)
+
+	defaultPort := ""
+	if len(appConfig.Ports) > 0 {
+		defaultPort = appConfig.Ports[0]
+	}
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	portName := flags.String("port", defaultPort, "controller port, serial device, tcp://host:port or auto")
+	retry := flags.Duration("retry", DAEMON_RETRY_INTERVAL, "delay between reconnection attempts")
+	flags.Parse(args)
+
+	if *portName == "" {
+		log.Fatalf("Port isn't set, use -port or Ports in %s", APP_CONFIG)
+	}
+
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		log.Printf("Got %v, shutting down", s)
+		close(stop)
+	}()
+
+	NewDaemon(&appConfig).Run(*portName, *retry, stop)
+}
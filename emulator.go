@@ -0,0 +1,284 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	EMULATOR_STATUS_INTERVAL = time.Second
+	EMULATOR_AMBIENT_TEMP    = 25.0
+	EMULATOR_MAX_RPM         = 2000.0
+	EMULATOR_THERMAL_TAU     = 20.0
+	EMULATOR_COOLING         = 0.6
+)
+
+// Emulator simulates the Intelligent Fan Controller: it streams FCD status
+// frames, answers FCQ with FCR and accepts FCS with FCA or ERR.
+type Emulator struct {
+	config  Config
+	temps   [4]float64
+	outputs [FAN_COUNT]float64
+	rpms    [FAN_COUNT * 2]float64
+	elapsed float64
+
+	lock      sync.Mutex
+	writeLock sync.Mutex
+}
+
+func NewEmulator() *Emulator {
+	em := &Emulator{
+		config: Config{
+			SensorTypes: SensorTypes{
+				SensorTypeA: SENSOR_TYPE_C,
+				SensorTypeB: SENSOR_TYPE_C,
+				SensorTypeC: SENSOR_NOT_CONNECTED,
+				SensorTypeD: SENSOR_TYPE_C,
+			},
+			Fan1Config: FanConfig{MinimumPower: 30, SensorControlling: SENSOR_A, MinimumTemperature: 30, MaximumTemperature: 50, FanTypeA: FAN_4_WIRE},
+			Fan2Config: FanConfig{MinimumPower: 20, SensorControlling: SENSOR_B_D, MinimumTemperature: 5, MaximumTemperature: 20, AllowStopped: true, FanTypeA: FAN_3_WIRE_X1_TACHO, FanTypeB: FAN_3_WIRE_X1_TACHO},
+			Fan3Config: FanConfig{MinimumPower: 50, SensorControlling: MANUAL_CONTROL, MinimumTemperature: 30, MaximumTemperature: 50, FanTypeA: FAN_2_WIRE},
+			Fan4Config: FanConfig{MinimumPower: 30, SensorControlling: SENSOR_A, MinimumTemperature: 30, MaximumTemperature: 50},
+		},
+	}
+	for i := range em.temps {
+		em.temps[i] = EMULATOR_AMBIENT_TEMP
+	}
+	return em
+}
+
+// heatLoad is the temperature rise above ambient the sensor would have
+// without any cooling, it varies slowly to make the curves visible.
+func (em *Emulator) heatLoad(sensor int) float64 {
+	if sensor == SENSOR_D {
+		return 2.0 * math.Sin(em.elapsed/600.0)
+	}
+	period := 120.0 + 60.0*float64(sensor)
+	return 25.0 + 15.0*math.Sin(2*math.Pi*em.elapsed/period)
+}
+
+func (em *Emulator) controlTemp(control int8) float64 {
+	switch control {
+	case SENSOR_A, SENSOR_B, SENSOR_C, SENSOR_D:
+		return em.temps[control]
+	case SENSOR_A_D, SENSOR_B_D, SENSOR_C_D:
+		return em.temps[control-SENSOR_A_D] - em.temps[SENSOR_D]
+	}
+	return 0
+}
+
+func (em *Emulator) fanOutput(fc *FanConfig) float64 {
+	if fc.SensorControlling == MANUAL_CONTROL {
+		return float64(fc.MinimumPower)
+	}
+	t := em.controlTemp(fc.SensorControlling)
+	minT, maxT := float64(fc.MinimumTemperature), float64(fc.MaximumTemperature)
+	switch {
+	case t < minT:
+		if fc.AllowStopped {
+			return 0
+		}
+		return float64(fc.MinimumPower)
+	case t >= maxT || maxT <= minT:
+		return 100
+	}
+	return float64(fc.MinimumPower) + (100-float64(fc.MinimumPower))*(t-minT)/(maxT-minT)
+}
+
+func (em *Emulator) fanRPM(fanType int8, output float64) float64 {
+	if fanType == FAN_NOT_CONNECTED || fanType == FAN_2_WIRE || output <= 0 {
+		return 0
+	}
+	return EMULATOR_MAX_RPM * output / 100 * (1 + (rand.Float64()-0.5)*0.04)
+}
+
+func (em *Emulator) step(dt float64) {
+	em.lock.Lock()
+	defer em.lock.Unlock()
+
+	em.elapsed += dt
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		fc := em.config.FanConfig(fan)
+		em.outputs[fan-1] = em.fanOutput(fc)
+		em.rpms[(fan-1)*2] = em.fanRPM(fc.FanTypeA, em.outputs[fan-1])
+		em.rpms[(fan-1)*2+1] = em.fanRPM(fc.FanTypeB, em.outputs[fan-1])
+	}
+	for sensor := range em.temps {
+		cooling := 0.0
+		for fan := 1; fan <= FAN_COUNT; fan++ {
+			fc := em.config.FanConfig(fan)
+			control := int(fc.SensorControlling)
+			if control >= SENSOR_A_D && control <= SENSOR_C_D {
+				control -= SENSOR_A_D
+			}
+			if control == sensor && fc.FanTypeA != FAN_NOT_CONNECTED {
+				cooling = math.Max(cooling, em.outputs[fan-1])
+			}
+		}
+		target := EMULATOR_AMBIENT_TEMP + em.heatLoad(sensor)*(1-EMULATOR_COOLING*cooling/100)
+		em.temps[sensor] += (target - em.temps[sensor]) * math.Min(dt/EMULATOR_THERMAL_TAU, 1)
+	}
+}
+
+func (em *Emulator) reportedTemp(sensorType int8, temp float64) int {
+	switch sensorType {
+	case SENSOR_TYPE_C:
+		return int(math.Round(temp))
+	case SENSOR_TYPE_F:
+		return int(math.Round(temp*9/5 + 32))
+	}
+	return 0
+}
+
+func (em *Emulator) statusStr() string {
+	em.lock.Lock()
+	defer em.lock.Unlock()
+
+	st := em.config.SensorTypes
+	values := []int{
+		em.reportedTemp(st.SensorTypeA, em.temps[SENSOR_A]),
+		em.reportedTemp(st.SensorTypeB, em.temps[SENSOR_B]),
+		em.reportedTemp(st.SensorTypeC, em.temps[SENSOR_C]),
+		em.reportedTemp(st.SensorTypeD, em.temps[SENSOR_D]),
+	}
+	for _, v := range em.outputs {
+		values = append(values, int(math.Round(v)))
+	}
+	for _, v := range em.rpms {
+		values = append(values, int(math.Round(v)))
+	}
+	s := "FCD"
+	for _, v := range values {
+		s += fmt.Sprintf(",%d", v)
+	}
+	return s
+}
+
+func (em *Emulator) handleCommand(cmd string) string {
+	sp := strings.Split(cmd, ",")
+	switch {
+	case checkCommand(sp[0], "FCQ") && len(sp) == 1:
+		em.lock.Lock()
+		defer em.lock.Unlock()
+		return "FCR," + configValuesToStr(&em.config)
+	case checkCommand(sp[0], "FCS") && len(sp) == 33:
+		config, err := parseConfigFields(sp)
+		if err == nil {
+			err = validateConfig(config)
+		}
+		if err != nil {
+			return "ERR:" + err.Error()
+		}
+		em.lock.Lock()
+		defer em.lock.Unlock()
+		em.config = *config
+		log.Printf("config=%s", ToJSON(config))
+		return "FCA"
+	}
+	return "ERR:Unknown command"
+}
+
+func (em *Emulator) write(w io.Writer, s string) error {
+	if DEBUG_INFO {
+		log.Printf("emulator out=%q", s)
+	}
+	em.writeLock.Lock()
+	defer em.writeLock.Unlock()
+	_, err := w.Write([]byte(s + FRAME_DELIMITER))
+	return err
+}
+
+// Serve speaks the controller protocol over rw until stop is closed or rw
+// fails.
+func (em *Emulator) Serve(rw io.ReadWriter, interval time.Duration, stop <-chan struct{}) error {
+	cmds := make(chan string, 16)
+	errc := make(chan error, 1)
+	go func() {
+		framer := NewFramer()
+		buf := make([]byte, 256)
+		for {
+			n, err := rw.Read(buf)
+			if err != nil && err != io.EOF {
+				errc <- err
+				return
+			}
+			for _, frame := range framer.Feed(buf[:n]) {
+				select {
+				case cmds <- string(frame):
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-errc:
+			return err
+		case cmd := <-cmds:
+			if DEBUG_INFO {
+				log.Printf("emulator in=%q", cmd)
+			}
+			if err := em.write(rw, em.handleCommand(cmd)); err != nil {
+				return err
+			}
+		case now := <-ticker.C:
+			em.step(now.Sub(last).Seconds())
+			last = now
+			if err := em.write(rw, em.statusStr()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func runEmulator(args []string) {
+	flags := flag.NewFlagSet("emulator", flag.ExitOnError)
+	interval := flags.Duration("interval", EMULATOR_STATUS_INTERVAL, "status frame interval")
+	link := flags.String("link", "", "create a symlink with this name to the emulator port")
+	flags.Parse(args)
+
+	port, portName, err := openEmulatorPort()
+	if err != nil {
+		log.Fatalf("err=%v", err)
+	}
+	defer port.Close()
+
+	if *link != "" {
+		os.Remove(*link)
+		if err := os.Symlink(portName, *link); err != nil {
+			log.Fatalf("err=%v", err)
+		}
+		defer os.Remove(*link)
+		portName = *link
+	}
+	log.Printf("Fan controller emulator is running on %s", portName)
+
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+
+	if err := NewEmulator().Serve(port, *interval, stop); err != nil {
+		log.Printf("err=%v", err)
+	}
+}
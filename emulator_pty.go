@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+type ptyPort struct {
+	*os.File
+	tty *os.File
+}
+
+func (p *ptyPort) Close() error {
+	p.tty.Close()
+	return p.File.Close()
+}
+
+// openEmulatorPort creates a pseudo-terminal, the emulator talks to the
+// master side and the application connects to the returned slave name.
+func openEmulatorPort() (io.ReadWriteCloser, string, error) {
+	master, tty, err := pty.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := term.MakeRaw(int(tty.Fd())); err != nil {
+		master.Close()
+		tty.Close()
+		return nil, "", err
+	}
+	return &ptyPort{File: master, tty: tty}, tty.Name(), nil
+}
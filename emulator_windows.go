@@ -0,0 +1,10 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openEmulatorPort() (io.ReadWriteCloser, string, error) {
+	return nil, "", errors.New("Emulator requires a pseudo-terminal which isn't available on Windows")
+}
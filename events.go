@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is passed to the subscribers of an EventBus, they switch on the
+// concrete type and ignore the events they don't need.
+type Event interface{}
+
+// Connected is published once the controller answered on Port.
+type Connected struct {
+	Port string
+}
+
+// Disconnected is published when the link is lost.
+type Disconnected struct {
+	Err error
+}
+
+// Closed is published when StopRead closed the connection on purpose.
+type Closed struct{}
+
+type StatusReceived struct {
+	Status Status
+}
+
+type ConfigReceived struct {
+	Config Config
+}
+
+// ApplySucceeded is the FCA reply to a config.
+type ApplySucceeded struct{}
+
+// ApplyFailed is the ERR reply to a config.
+type ApplyFailed struct {
+	Message string
+}
+
+// LinkError is a write error which didn't close the link yet.
+type LinkError struct {
+	Err error
+}
+
+// LinkStale is published by Watchdog when no frame arrived since Last,
+// LinkFresh when frames arrive again.
+type LinkStale struct {
+	Last time.Time
+}
+
+type LinkFresh struct{}
+
+// TempEmergency is published by TempAlarmMonitor while it runs all fans at
+// 100 %, fan curves don't write the fans while it's Active.
+type TempEmergency struct {
+	Active bool
+}
+
+// Reconnecting is published by Reconnector before it waits Delay for the
+// next attempt.
+type Reconnecting struct {
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+type Reconnected struct {
+	Port string
+}
+
+// EventBus passes events to the subscribed handlers in the order they
+// subscribed, the handlers run on the goroutine publishing the event.
+type EventBus struct {
+	lock     sync.RWMutex
+	handlers []func(Event)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (bus *EventBus) Subscribe(handler func(Event)) {
+	bus.lock.Lock()
+	bus.handlers = append(bus.handlers, handler)
+	bus.lock.Unlock()
+}
+
+func (bus *EventBus) Publish(ev Event) {
+	bus.lock.RLock()
+	handlers := bus.handlers
+	bus.lock.RUnlock()
+	for _, handler := range handlers {
+		handler(ev)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	bus.Publish(Connected{Port: "mem"})
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprint(i)
+		bus.Subscribe(func(ev Event) {
+			switch ev := ev.(type) {
+			case Connected:
+				got = append(got, name+":"+ev.Port)
+			case ApplyFailed:
+				got = append(got, name+":"+ev.Message)
+			}
+		})
+	}
+	bus.Publish(Connected{Port: "COM3"})
+	bus.Publish(StatusReceived{})
+	bus.Publish(ApplyFailed{Message: "Invalid"})
+	if s := strings.Join(got, " "); s != "0:COM3 1:COM3 2:COM3 0:Invalid 1:Invalid 2:Invalid" {
+		t.Fatalf("events %s", s)
+	}
+}
+
+func TestEventBusSubscribeFromHandler(t *testing.T) {
+	bus := NewEventBus()
+	var lock sync.Mutex
+	count := 0
+	handler := func(Event) {
+		lock.Lock()
+		count++
+		lock.Unlock()
+	}
+	// a handler subscribing another one doesn't deadlock, the new handler
+	// gets the next event
+	bus.Subscribe(func(ev Event) {
+		if _, ok := ev.(Connected); ok {
+			bus.Subscribe(handler)
+		}
+	})
+	bus.Publish(Connected{})
+	if count != 0 {
+		t.Fatalf("new handler got the event it was subscribed in")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				bus.Publish(StatusReceived{})
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 400 {
+		t.Fatalf("count %d", count)
+	}
+}
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"github.com/andlabs/ui"
-	_ "github.com/andlabs/ui/winmanifest"
+	"os"
 )
 
 const (
@@ -12,11 +11,23 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "emulator":
+			runEmulator(os.Args[2:])
+			return
+		case "daemon":
+			runDaemon(os.Args[2:])
+			return
+		case "status":
+			os.Exit(runStatus(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		}
+	}
+
 	var appConfig AppConfig
 	readAppConfig(&appConfig)
 
-	serial := NewSerial(&appConfig)
-	appGUI := NewAppGUI(serial, &appConfig)
-
-	ui.Main(appGUI.SetupUI)
+	runGUI(&appConfig)
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	FAN_SETTLE_TIME     = time.Second * 10
+	FAN_ALERT_TIME      = time.Second * 15
+	FAN_DRIFT_RATIO     = 0.3
+	FAN_MISMATCH_RATIO  = 0.3
+	FAN_BASELINE_WEIGHT = 0.05
+)
+
+func hasTacho(fanType int8) bool {
+	return fanType >= FAN_3_WIRE_X1_TACHO && fanType <= FAN_4_WIRE
+}
+
+// fanChannel is the state of one tacho input, baseline is the learned RPM
+// for each 10 % step of the output.
+type fanChannel struct {
+	name       string
+	stallSince time.Time
+	driftSince time.Time
+	baseline   [11]float64
+}
+
+// FanMonitor watches the status of fans with a tacho and raises alerts when
+// a fan stalls, runs far below its learned RPM or differs from the other fan
+// of the same output.
+type FanMonitor struct {
+	alerts *Alerts
+
+	lock          sync.Mutex
+	config        Config
+	channels      [FAN_COUNT * 2]fanChannel
+	output        [FAN_COUNT]int8
+	outputSince   [FAN_COUNT]time.Time
+	mismatchSince [FAN_COUNT]time.Time
+}
+
+func NewFanMonitor(alerts *Alerts) *FanMonitor {
+	monitor := &FanMonitor{alerts: alerts}
+	for i := range monitor.channels {
+		monitor.channels[i].name = fmt.Sprintf("%d%c", i/2+1, 'A'+i%2)
+	}
+	return monitor
+}
+
+func (m *FanMonitor) OnEvent(ev Event) {
+	switch ev := ev.(type) {
+	case StatusReceived:
+		m.OnStatus(ev.Status)
+	case ConfigReceived:
+		m.OnConfig(ev.Config)
+	case Disconnected:
+		m.OnDisconnect(ev.Err)
+	case Closed:
+		m.OnDisconnect(nil)
+	}
+}
+
+func (m *FanMonitor) OnStatus(status Status) {
+	m.check(status, time.Now())
+}
+
+func (m *FanMonitor) check(status Status, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		fc := m.config.FanConfig(fan)
+		output := status.FanOutput(fan)
+		if output != m.output[fan-1] || m.outputSince[fan-1].IsZero() {
+			m.output[fan-1] = output
+			m.outputSince[fan-1] = now
+		}
+		settled := now.Sub(m.outputSince[fan-1]) >= FAN_SETTLE_TIME
+
+		rpmA, rpmB := status.FanRPM(fan)
+		m.checkChannel(&m.channels[(fan-1)*2], hasTacho(fc.FanTypeA), output, rpmA, settled, now)
+		m.checkChannel(&m.channels[(fan-1)*2+1], hasTacho(fc.FanTypeB), output, rpmB, settled, now)
+
+		key := fmt.Sprintf("fan%d.mismatch", fan)
+		paired := fc.FanTypeA == fc.FanTypeB && hasTacho(fc.FanTypeA)
+		if paired && settled && rpmA > 0 && rpmB > 0 && rpmMismatch(rpmA, rpmB) {
+			if m.mismatchSince[fan-1].IsZero() {
+				m.mismatchSince[fan-1] = now
+			}
+			if now.Sub(m.mismatchSince[fan-1]) >= FAN_ALERT_TIME {
+				m.alerts.Raise(key, ALERT_WARNING, fmt.Sprintf("Fans %dA and %dB mismatch: %d and %d RPM", fan, fan, rpmA, rpmB))
+			}
+		} else {
+			m.mismatchSince[fan-1] = time.Time{}
+			m.alerts.Clear(key)
+		}
+	}
+}
+
+func rpmMismatch(a, b int16) bool {
+	lo, hi := float64(a), float64(b)
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return hi-lo > hi*FAN_MISMATCH_RATIO
+}
+
+func (m *FanMonitor) checkChannel(ch *fanChannel, tacho bool, output int8, rpm int16, settled bool, now time.Time) {
+	stallKey := "fan" + ch.name + ".stall"
+	driftKey := "fan" + ch.name + ".drift"
+	if !tacho || output <= 0 || !settled {
+		ch.stallSince = time.Time{}
+		ch.driftSince = time.Time{}
+		if !tacho || output <= 0 {
+			m.alerts.Clear(stallKey)
+			m.alerts.Clear(driftKey)
+		}
+		return
+	}
+
+	step := int(output+5) / 10
+	if step > 10 {
+		step = 10
+	}
+	baseline := &ch.baseline[step]
+
+	if rpm == 0 {
+		if ch.stallSince.IsZero() {
+			ch.stallSince = now
+		}
+		if now.Sub(ch.stallSince) >= FAN_ALERT_TIME {
+			m.alerts.Raise(stallKey, ALERT_CRITICAL, fmt.Sprintf("Fan %s stalled: 0 RPM at %d %% output", ch.name, output))
+		}
+	} else {
+		ch.stallSince = time.Time{}
+		m.alerts.Clear(stallKey)
+	}
+
+	if rpm > 0 && *baseline > 0 && float64(rpm) < *baseline*(1-FAN_DRIFT_RATIO) {
+		if ch.driftSince.IsZero() {
+			ch.driftSince = now
+		}
+		if now.Sub(ch.driftSince) >= FAN_ALERT_TIME {
+			m.alerts.Raise(driftKey, ALERT_WARNING, fmt.Sprintf("Fan %s RPM drift: %d RPM, expected about %.0f RPM at %d %% output",
+				ch.name, rpm, *baseline, output))
+		}
+		return
+	}
+	ch.driftSince = time.Time{}
+	m.alerts.Clear(driftKey)
+
+	if rpm > 0 {
+		if *baseline == 0 {
+			*baseline = float64(rpm)
+		} else {
+			*baseline += (float64(rpm) - *baseline) * FAN_BASELINE_WEIGHT
+		}
+	}
+}
+
+func (m *FanMonitor) OnConfig(config Config) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		old, fc := m.config.FanConfig(fan), config.FanConfig(fan)
+		// a different fan type means a different fan
+		if old.FanTypeA != fc.FanTypeA {
+			m.channels[(fan-1)*2].baseline = [11]float64{}
+		}
+		if old.FanTypeB != fc.FanTypeB {
+			m.channels[(fan-1)*2+1].baseline = [11]float64{}
+		}
+	}
+	m.config = config
+}
+
+func (m *FanMonitor) OnDisconnect(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i := range m.channels {
+		ch := &m.channels[i]
+		ch.stallSince, ch.driftSince = time.Time{}, time.Time{}
+		m.alerts.Clear("fan" + ch.name + ".stall")
+		m.alerts.Clear("fan" + ch.name + ".drift")
+	}
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		m.outputSince[fan-1] = time.Time{}
+		m.mismatchSince[fan-1] = time.Time{}
+		m.alerts.Clear(fmt.Sprintf("fan%d.mismatch", fan))
+	}
+}
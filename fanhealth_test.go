@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func activeKeys(alerts *Alerts) string {
+	var keys []string
+	for _, alert := range alerts.Active() {
+		keys = append(keys, alert.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestFanMonitor(t *testing.T) {
+	// fan 1 has two 4 wire fans, fan 3 a 2 wire fan without tacho
+	config := testConfig
+	config.Fan1Config.FanTypeB = FAN_4_WIRE
+
+	type step struct {
+		second     int
+		output     int8
+		rpmA, rpmB int16
+		alerts     string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stall", []step{
+			{0, 50, 0, 0, ""},
+			{10, 50, 0, 0, ""},
+			{24, 50, 0, 0, ""},
+			{25, 50, 0, 0, "fan1A.stall,fan1B.stall"},
+			{26, 50, 800, 0, "fan1B.stall"},
+			{27, 50, 800, 800, ""},
+		}},
+		{"stopped", []step{
+			{0, 0, 0, 0, ""},
+			{60, 0, 0, 0, ""},
+		}},
+		{"output change", []step{
+			{0, 50, 0, 0, ""},
+			{20, 60, 0, 0, ""},
+			{30, 60, 0, 0, ""},
+			{44, 60, 0, 0, ""},
+			{45, 60, 0, 0, "fan1A.stall,fan1B.stall"},
+			{46, 0, 0, 0, ""},
+		}},
+		{"drift", []step{
+			{0, 50, 1000, 1000, ""},
+			{10, 50, 1000, 1000, ""},
+			{11, 50, 650, 650, ""},
+			{25, 50, 650, 650, ""},
+			{26, 50, 650, 650, "fan1A.drift,fan1B.drift"},
+			{27, 50, 750, 750, ""},
+		}},
+		{"drift at another output", []step{
+			{0, 50, 1000, 1000, ""},
+			{10, 50, 1000, 1000, ""},
+			{11, 30, 650, 650, ""},
+			{50, 30, 650, 650, ""},
+		}},
+		{"mismatch", []step{
+			{0, 50, 1000, 1000, ""},
+			{10, 50, 1000, 600, ""},
+			{24, 50, 1000, 600, ""},
+			{25, 50, 1000, 600, "fan1.mismatch"},
+			{26, 50, 1000, 900, ""},
+		}},
+	}
+	for _, test := range tests {
+		alerts := NewAlerts(&AppConfig{})
+		m := NewFanMonitor(alerts)
+		m.OnConfig(config)
+		start := time.Now()
+		for _, s := range test.steps {
+			status := Status{
+				Outputs: Outputs{Fan1: s.output, Fan3: s.output},
+				RPMS:    RPMS{Fan1A: s.rpmA, Fan1B: s.rpmB},
+			}
+			m.check(status, start.Add(time.Second*time.Duration(s.second)))
+			if keys := activeKeys(alerts); keys != s.alerts {
+				t.Errorf("%s at %d s: alerts %q, want %q", test.name, s.second, keys, s.alerts)
+			}
+		}
+	}
+}
+
+func TestFanMonitorDisconnect(t *testing.T) {
+	alerts := NewAlerts(&AppConfig{})
+	m := NewFanMonitor(alerts)
+	m.OnConfig(testConfig)
+	start := time.Now()
+	status := Status{Outputs: Outputs{Fan1: 50}}
+	for _, d := range []time.Duration{0, FAN_SETTLE_TIME, FAN_SETTLE_TIME + FAN_ALERT_TIME} {
+		m.check(status, start.Add(d))
+	}
+	if keys := activeKeys(alerts); keys != "fan1A.stall" {
+		t.Fatalf("alerts %q", keys)
+	}
+	m.OnEvent(Disconnected{})
+	if keys := activeKeys(alerts); keys != "" {
+		t.Fatalf("alerts %q after disconnect", keys)
+	}
+}
+
+func TestRPMMismatch(t *testing.T) {
+	tests := []struct {
+		a, b     int16
+		mismatch bool
+	}{
+		{1000, 1000, false},
+		{1000, 700, false},
+		{1000, 699, true},
+		{699, 1000, true},
+	}
+	for _, test := range tests {
+		if rpmMismatch(test.a, test.b) != test.mismatch {
+			t.Errorf("%d %d mismatch %v", test.a, test.b, !test.mismatch)
+		}
+	}
+}
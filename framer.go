@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"log"
+)
+
+const (
+	FRAME_DELIMITER = "\r\n"
+	MAX_FRAME_SIZE  = 1024
+)
+
+// Framer keeps bytes received from the controller between reads and splits
+// them into complete "\r\n" terminated frames.
+type Framer struct {
+	buf []byte
+}
+
+func NewFramer() *Framer {
+	return &Framer{buf: make([]byte, 0, MAX_FRAME_SIZE)}
+}
+
+func (fr *Framer) Reset() {
+	fr.buf = fr.buf[:0]
+}
+
+// Feed appends d to the pending bytes and returns every complete frame in
+// the order it was received. Frames are returned without the delimiter and
+// without the leading 0x0 bytes the controller sometimes sends.
+func (fr *Framer) Feed(d []byte) [][]byte {
+	fr.buf = append(fr.buf, d...)
+
+	frames := make([][]byte, 0)
+	delim := []byte(FRAME_DELIMITER)
+	start := 0
+	for {
+		i := bytes.Index(fr.buf[start:], delim)
+		if i < 0 {
+			break
+		}
+		frame := bytes.TrimLeft(fr.buf[start:start+i], "\x00")
+		if len(frame) > 0 {
+			frames = append(frames, append([]byte(nil), frame...))
+		}
+		start += i + len(delim)
+	}
+
+	n := copy(fr.buf, fr.buf[start:])
+	fr.buf = fr.buf[:n]
+	if len(fr.buf) > MAX_FRAME_SIZE {
+		if DEBUG_INFO {
+			log.Printf("drop unterminated data=%q", fr.buf)
+		}
+		fr.buf = fr.buf[:0]
+	}
+
+	return frames
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFramerFeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		frames []string
+	}{
+		{"complete", []string{"FCA\r\n"}, []string{"FCA"}},
+		{"split", []string{"FCD,25,2", "6,0\r", "\n"}, []string{"FCD,25,26,0"}},
+		{"several", []string{"FCA\r\nERR:Invalid\r\nFCA\r\n"}, []string{"FCA", "ERR:Invalid", "FCA"}},
+		{"leading zero", []string{"\x00FCA\r\n", "\x00\x00ERR:x\r\n"}, []string{"FCA", "ERR:x"}},
+		{"only zeros", []string{"\x00\r\n\r\nFCA\r\n"}, []string{"FCA"}},
+		{"trailing partial", []string{"FCA\r\nFCD,1", ",2\r\nFC"}, []string{"FCA", "FCD,1,2"}},
+	}
+	for _, test := range tests {
+		fr := NewFramer()
+		var frames []string
+		for _, chunk := range test.chunks {
+			for _, frame := range fr.Feed([]byte(chunk)) {
+				frames = append(frames, string(frame))
+			}
+		}
+		if strings.Join(frames, "|") != strings.Join(test.frames, "|") {
+			t.Errorf("%s: frames %q, want %q", test.name, frames, test.frames)
+		}
+	}
+}
+
+func TestFramerOverflow(t *testing.T) {
+	fr := NewFramer()
+	if frames := fr.Feed([]byte(strings.Repeat("x", MAX_FRAME_SIZE+1))); len(frames) != 0 {
+		t.Fatalf("unterminated data returned %d frames", len(frames))
+	}
+	// the dropped bytes don't prefix the next frame
+	frames := fr.Feed([]byte("xx\r\nFCA\r\n"))
+	if len(frames) != 2 || string(frames[0]) != "xx" || string(frames[1]) != "FCA" {
+		t.Fatalf("frames after overflow %q", frames)
+	}
+
+	fr = NewFramer()
+	fr.Feed([]byte(strings.Repeat("x", MAX_FRAME_SIZE)))
+	frames = fr.Feed([]byte("\r\n"))
+	if len(frames) != 1 || len(frames[0]) != MAX_FRAME_SIZE {
+		t.Fatalf("frame of MAX_FRAME_SIZE wasn't kept")
+	}
+}
@@ -1,16 +1,31 @@
+//go:build !nogui
+// +build !nogui
+
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/andlabs/ui"
+	_ "github.com/andlabs/ui/winmanifest"
 	"github.com/getlantern/systray"
 
 	"./icon"
 )
 
+const GUI_REPAINT_INTERVAL = time.Millisecond * 500
+
+const (
+	REPAINT_STATUS = 1 << iota
+	REPAINT_CONFIG
+)
+
 type AppGUI struct {
 	mainWindow               *ui.Window
 	selectPortWindow         *ui.Window
@@ -21,11 +36,37 @@ type AppGUI struct {
 	statusPage                             StatusPage
 	sensorPage                             SensorPage
 	fan1Page, fan2Page, fan3Page, fan4Page FanPage
+	historyPage                            HistoryPage
+
+	profileEdit  *ui.EditableCombobox
+	profileLabel *ui.Label
+	profileNames []string
+	alertLabel   *ui.Label
 
 	showAppMenu, quitMenu *systray.MenuItem
+	profilesMenu          *systray.MenuItem
+	profileMenus          map[string]*systray.MenuItem
 
 	serial    *Serial
+	reconnect *Reconnector
+	history   *History
+	alerts    *Alerts
+	curves    *FanCurves
+	host      *HostSensors
 	appConfig *AppConfig
+
+	edited bool
+
+	// hostTemps are read with the status, not on the UI thread
+	hostLock  sync.Mutex
+	hostTemps []int
+	hostValid []bool
+
+	repaintLock    sync.Mutex
+	repaintPending int
+	repaintQueued  bool
+	lastRepaint    time.Time
+	visible        bool
 }
 
 type StatusPage struct {
@@ -35,6 +76,9 @@ type StatusPage struct {
 	TempALabel, TempBLabel, TempCLabel, TempDLabel                                                 *ui.Label
 	Fan1ALabel, Fan1BLabel, Fan2ALabel, Fan2BLabel, Fan3ALabel, Fan3BLabel, Fan4ALabel, Fan4BLabel *ui.Label
 	Output1Label, Output2Label, Output3Label, Output4Label                                         *ui.Label
+	Host                                                                                           []*ui.ProgressBar
+	HostLabels                                                                                     []*ui.Label
+	StaleLabel                                                                                     *ui.Label
 }
 
 type SensorPage struct {
@@ -47,18 +91,204 @@ type FanPage struct {
 	Power              *ui.Spinbox
 	MinTemp            *ui.Spinbox
 	MaxTemp            *ui.Spinbox
+	MinTempUnit        *ui.Label
+	MaxTempUnit        *ui.Label
 	AllowStop          *ui.Checkbox
+	PIDGroup           *ui.Group
+	PIDScale           *ui.Label
+	PIDLabel           *ui.Label
+	PIDArea            *ui.Area
+	PIDChart           *PIDChart
 }
 
 func NewAppGUI(serial *Serial, appConfig *AppConfig) *AppGUI {
 	appGUI := AppGUI{
-		serial:    serial,
-		appConfig: appConfig,
+		serial:       serial,
+		appConfig:    appConfig,
+		profileMenus: make(map[string]*systray.MenuItem),
 	}
-	serial.appGUI = &appGUI
+	serial.Subscribe(appGUI.OnEvent)
 	return &appGUI
 }
 
+func runGUI(appConfig *AppConfig) {
+	serial := NewSerial(appConfig)
+	appGUI := NewAppGUI(serial, appConfig)
+	appGUI.reconnect = NewReconnector(serial)
+	serial.Subscribe(appGUI.reconnect.OnEvent)
+	appGUI.history = NewHistory(appConfig)
+	serial.Subscribe(appGUI.history.OnEvent)
+	appGUI.alerts = NewAlerts(appConfig)
+	appGUI.alerts.AddListener(appGUI)
+	serial.Subscribe(NewFanMonitor(appGUI.alerts).OnEvent)
+
+	serial.Subscribe(NewTempAlarmMonitor(serial, appGUI.alerts, appConfig).OnEvent)
+	serial.Subscribe(NewHooks(appConfig).OnEvent)
+	serial.Subscribe(NewWatchdog(serial, appGUI.alerts, appConfig).OnEvent)
+	appGUI.host = NewHostSensors(appConfig)
+	appGUI.curves = NewFanCurves(serial, appGUI.host, appConfig)
+	serial.Subscribe(appGUI.curves.OnEvent)
+	serial.SetFallback(appGUI.curves.release)
+	scheduler := NewScheduler(serial, appConfig)
+	serial.Subscribe(scheduler.OnEvent)
+	scheduler.Start()
+	NewAPIServer(serial, appConfig).Start()
+	mqttBridge := NewMQTTBridge(serial, appConfig)
+	serial.Subscribe(mqttBridge.OnEvent)
+	mqttBridge.Start()
+
+	ui.Main(appGUI.SetupUI)
+}
+
+func (app *AppGUI) OnEvent(ev Event) {
+	switch ev := ev.(type) {
+	case StatusReceived:
+		app.readHostTemps()
+		app.queueRepaint(REPAINT_STATUS)
+	case ConfigReceived:
+		app.queueRepaint(REPAINT_CONFIG)
+		app.updateActiveProfile(ev.Config)
+	case LinkError:
+		app.ShowError(ev.Err, true)
+	case Reconnecting:
+		// the main window stays open while the reconnector retries the port
+		app.alerts.Raise("link", ALERT_CRITICAL, fmt.Sprintf("Connection lost (%v), reconnecting in %s, attempt %d", ev.Err, ev.Delay, ev.Attempt))
+		app.setTitle(getAppTitle() + " - Reconnecting…")
+	case Reconnected:
+		app.alerts.Clear("link")
+		app.setTitle(getAppTitle())
+	case LinkStale:
+		// the tray shows the stale alert
+		ui.QueueMain(func() {
+			app.statusPage.StaleLabel.SetText("Stale data, last update " + ev.Last.Format("15:04:05"))
+		})
+	case LinkFresh:
+		ui.QueueMain(func() {
+			app.statusPage.StaleLabel.SetText("")
+		})
+	}
+}
+
+// readHostTemps reads the host sensors for the next status page repaint.
+func (app *AppGUI) readHostTemps() {
+	names := app.host.Names()
+	temps := make([]int, len(names))
+	valid := make([]bool, len(names))
+	for i, name := range names {
+		temps[i], valid[i] = app.host.Temperature(name)
+	}
+	app.hostLock.Lock()
+	app.hostTemps, app.hostValid = temps, valid
+	app.hostLock.Unlock()
+}
+
+// applyConfig sends config from the window, the action buttons stay disabled
+// until the controller answered or APPLY_TIMEOUT passed.
+func (app *AppGUI) applyConfig(config *Config) {
+	app.disableActionButtons()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), APPLY_TIMEOUT)
+		defer cancel()
+		app.applyDone(app.serial.Apply(ctx, config))
+	}()
+}
+
+func (app *AppGUI) applyDone(err error) {
+	ui.QueueMain(func() {
+		if err != nil {
+			app.UpdateActionButtons(true)
+			app.ShowError(err, true)
+			return
+		}
+		app.UpdateActionButtons(false)
+		app.ShowMessage("Config successfully applied")
+	})
+}
+
+// queueRepaint marks pages as outdated and schedules one repaint on the UI
+// thread, frames arriving before it are coalesced. Nothing is scheduled while
+// the main window is hidden, setVisible repaints once it's shown again.
+func (app *AppGUI) queueRepaint(pages int) {
+	app.repaintLock.Lock()
+	defer app.repaintLock.Unlock()
+	app.repaintPending |= pages
+	if app.repaintPending == 0 || app.repaintQueued || !app.visible {
+		return
+	}
+	app.repaintQueued = true
+	delay := GUI_REPAINT_INTERVAL - time.Since(app.lastRepaint)
+	if delay < 0 {
+		delay = 0
+	}
+	time.AfterFunc(delay, func() {
+		ui.QueueMain(app.repaint)
+	})
+}
+
+func (app *AppGUI) repaint() {
+	app.repaintLock.Lock()
+	pages := app.repaintPending
+	app.repaintQueued = false
+	if !app.visible {
+		app.repaintLock.Unlock()
+		return
+	}
+	app.repaintPending = 0
+	app.lastRepaint = time.Now()
+	app.repaintLock.Unlock()
+
+	if pages&REPAINT_STATUS != 0 {
+		app.UpdateStatusPage()
+		app.updatePIDViews()
+	}
+	// keep changes which aren't applied yet
+	if pages&REPAINT_CONFIG != 0 && !app.edited {
+		app.UpdateConfigPages()
+	}
+}
+
+// setVisible is called on the UI thread when the main window is shown or
+// hidden.
+func (app *AppGUI) setVisible(visible bool) {
+	app.repaintLock.Lock()
+	app.visible = visible
+	app.repaintLock.Unlock()
+	if visible {
+		app.queueRepaint(0)
+		app.refreshHistory()
+	}
+}
+
+func (app *AppGUI) isVisible() bool {
+	app.repaintLock.Lock()
+	defer app.repaintLock.Unlock()
+	return app.visible
+}
+
+func (app *AppGUI) setTitle(title string) {
+	ui.QueueMain(func() {
+		if app.mainWindow != nil {
+			app.mainWindow.SetTitle(title)
+		}
+	})
+}
+
+func (app *AppGUI) OnAlert(alert Alert) {
+	app.updateAlerts()
+}
+
+func (app *AppGUI) connect(portName string) bool {
+	if err := app.reconnect.Connect(portName); err != nil {
+		app.ShowError(err, false)
+		return false
+	}
+
+	app.edited = false
+	app.queueRepaint(REPAINT_STATUS | REPAINT_CONFIG)
+	app.UpdateConfig(portName)
+	return true
+}
+
 func (app *AppGUI) ShowError(err error, main bool) {
 	ui.QueueMain(func() {
 		var window *ui.Window
@@ -118,7 +348,7 @@ func (app *AppGUI) SetupUI() {
 		return true
 	})
 
-	if !(app.appConfig.AutoStartInSystray && app.serial.ConnectToController(app.portEdit.Text())) {
+	if !(app.appConfig.AutoStartInSystray && app.connect(app.portEdit.Text())) {
 		app.showSelectPortWindow()
 	}
 
@@ -161,12 +391,51 @@ func (app *AppGUI) makeSelectPortWindow() {
 
 	connectButton := ui.NewButton("Connect")
 	connectButton.OnClicked(func(*ui.Button) {
-		if app.serial.ConnectToController(app.portEdit.Text()) {
+		if app.connect(app.portEdit.Text()) {
 			app.showMainWindow()
 		}
 	})
 	grid1.Append(connectButton, 2, 1, 1, 1, false, ui.AlignEnd, false, ui.AlignCenter)
 
+	scanLabel := ui.NewLabel("")
+	grid1.Append(scanLabel, 0, 2, 3, 1, false, ui.AlignStart, false, ui.AlignCenter)
+	scanButton := ui.NewButton("Scan")
+	scanButton.OnClicked(func(*ui.Button) {
+		scanButton.Disable()
+		connectButton.Disable()
+		scanLabel.SetText("Scanning...")
+		go func() {
+			results := ScanPorts()
+			ui.QueueMain(func() {
+				app.showScanResults(results, scanLabel)
+				scanButton.Enable()
+				connectButton.Enable()
+			})
+		}()
+	})
+	grid1.Append(scanButton, 0, 1, 1, 1, false, ui.AlignStart, false, ui.AlignCenter)
+}
+
+// showScanResults adds the found ports to the port list and selects the first
+// one.
+func (app *AppGUI) showScanResults(results []ScanResult, label *ui.Label) {
+	if len(results) == 0 {
+		label.SetText(ErrNoController.Error())
+		return
+	}
+	lines := make([]string, len(results))
+	for i, result := range results {
+		lines[i] = result.String()
+		known := false
+		for _, port := range app.appConfig.Ports {
+			known = known || port == result.Port
+		}
+		if !known {
+			app.portEdit.Append(result.Port)
+		}
+	}
+	app.portEdit.SetText(results[0].Port)
+	label.SetText(strings.Join(lines, "\n"))
 }
 
 func setVisibleMenu(visible bool, menu *systray.MenuItem) {
@@ -201,6 +470,13 @@ func (app *AppGUI) onSystrayReady() {
 			app.showMainWindow()
 		}
 	}()
+	app.profilesMenu = systray.AddMenuItem("Profiles", "Apply config profile")
+	ui.QueueMain(func() {
+		for _, name := range app.profileNames {
+			app.addProfileMenu(name)
+		}
+		app.updateActiveProfile(app.serial.GetConfig())
+	})
 	app.quitMenu = systray.AddMenuItem("Quit", "Quit")
 	go func() {
 		<-app.quitMenu.ClickedCh
@@ -241,15 +517,20 @@ func (app *AppGUI) makeMainWindow() {
 	tab.SetMargined(4, true)
 	tab.Append("Fans 4A and 4B", app.makeFansPage(&app.fan4Page, 4))
 	tab.SetMargined(5, true)
+	tab.Append("History", app.makeHistoryPage())
+	tab.SetMargined(6, true)
 
 	gridBtns := ui.NewGrid()
 	gridBtns.SetPadded(true)
 	grid.Append(gridBtns, 1, 0, 1, 1, false, ui.AlignFill, false, ui.AlignEnd)
 
+	grid.Append(app.makeProfileBar(), 0, 1, 2, 1, true, ui.AlignFill, false, ui.AlignEnd)
+	app.alertLabel = ui.NewLabel("")
+	grid.Append(app.alertLabel, 0, 2, 2, 1, true, ui.AlignStart, false, ui.AlignEnd)
+
 	app.applyButton = ui.NewButton("Apply")
 	app.applyButton.OnClicked(func(*ui.Button) {
-		app.disableActionButtons()
-		app.serial.ApplyConfig(app.getConfig())
+		app.applyConfig(app.getConfig())
 	})
 	gridBtns.Append(app.applyButton, 0, 0, 1, 1, false, ui.AlignFill, false, ui.AlignEnd)
 	app.resetButton = ui.NewButton("Reset")
@@ -267,6 +548,123 @@ func (app *AppGUI) makeMainWindow() {
 	app.UpdateActionButtons(false)
 }
 
+func (app *AppGUI) makeProfileBar() ui.Control {
+	grid := ui.NewGrid()
+	grid.SetPadded(true)
+
+	grid.Append(ui.NewLabel("Profile:"), 0, 0, 1, 1, false, ui.AlignEnd, false, ui.AlignCenter)
+	app.profileEdit = ui.NewEditableCombobox()
+	app.profileNames = listProfiles()
+	for _, name := range app.profileNames {
+		app.profileEdit.Append(name)
+	}
+	grid.Append(app.profileEdit, 1, 0, 1, 1, true, ui.AlignFill, false, ui.AlignCenter)
+
+	saveButton := ui.NewButton("Save")
+	saveButton.OnClicked(func(*ui.Button) {
+		app.saveProfile(app.profileEdit.Text())
+	})
+	grid.Append(saveButton, 2, 0, 1, 1, false, ui.AlignFill, false, ui.AlignCenter)
+	applyButton := ui.NewButton("Apply Profile")
+	applyButton.OnClicked(func(*ui.Button) {
+		app.applyProfile(app.profileEdit.Text())
+	})
+	grid.Append(applyButton, 3, 0, 1, 1, false, ui.AlignFill, false, ui.AlignCenter)
+
+	app.profileLabel = ui.NewLabel("")
+	grid.Append(app.profileLabel, 0, 1, 4, 1, false, ui.AlignStart, false, ui.AlignCenter)
+
+	return grid
+}
+
+func (app *AppGUI) saveProfile(name string) {
+	config := app.getConfig()
+	if err := saveProfile(name, config); err != nil {
+		app.ShowError(err, true)
+		return
+	}
+	exists := false
+	for _, v := range app.profileNames {
+		if v == name {
+			exists = true
+		}
+	}
+	if !exists {
+		app.profileNames = append(app.profileNames, name)
+		app.profileEdit.Append(name)
+		app.addProfileMenu(name)
+	}
+	app.updateActiveProfile(app.serial.GetConfig())
+}
+
+func (app *AppGUI) applyProfile(name string) {
+	config, err := loadProfile(name)
+	if err != nil {
+		app.ShowError(err, true)
+		return
+	}
+	app.applyConfig(&config)
+}
+
+func (app *AppGUI) addProfileMenu(name string) {
+	if app.profilesMenu == nil {
+		return
+	}
+	menu := app.profilesMenu.AddSubMenuItem(name, "Apply profile "+name)
+	app.profileMenus[name] = menu
+	go func() {
+		for {
+			<-menu.ClickedCh
+			ui.QueueMain(func() {
+				app.applyProfile(name)
+			})
+		}
+	}()
+}
+
+func (app *AppGUI) updateActiveProfile(config Config) {
+	active := matchProfile(config)
+	ui.QueueMain(func() {
+		if active != "" {
+			app.profileLabel.SetText("Controller config matches profile \"" + active + "\"")
+		} else {
+			app.profileLabel.SetText("Controller config doesn't match any profile")
+		}
+		for name, menu := range app.profileMenus {
+			if name == active {
+				menu.Check()
+			} else {
+				menu.Uncheck()
+			}
+		}
+	})
+}
+
+// updateAlerts shows the active alerts in the main window and in the systray
+// tooltip.
+func (app *AppGUI) updateAlerts() {
+	alerts := app.alerts.Active()
+	text := ""
+	for _, alert := range alerts {
+		if text != "" {
+			text += "\n"
+		}
+		text += strings.ToUpper(alert.Severity[:1]) + alert.Severity[1:] + ": " + alert.Message
+	}
+	ui.QueueMain(func() {
+		if app.alertLabel != nil {
+			app.alertLabel.SetText(text)
+		}
+		if text != "" {
+			systray.SetTooltip(getAppTitle() + "\n" + text)
+			systray.SetTitle(fmt.Sprintf("%s (%d alerts)", getAppTitle(), len(alerts)))
+		} else {
+			systray.SetTooltip(getAppTitle())
+			systray.SetTitle(getAppTitle())
+		}
+	})
+}
+
 func (app *AppGUI) addProgressBarOnStatusPage(index int, name, value string, grid *ui.Grid) (*ui.ProgressBar, *ui.Label) {
 	progressBar := ui.NewProgressBar()
 	progressBar.SetValue(0)
@@ -285,6 +683,9 @@ func (app *AppGUI) makeStatusPage() ui.Control {
 	hbox.SetPadded(true)
 	grid.Append(hbox, 0, 0, 1, 1, true, ui.AlignCenter, true, ui.AlignCenter)
 
+	app.statusPage.StaleLabel = ui.NewLabel("")
+	hbox.Append(app.statusPage.StaleLabel, false)
+
 	grid1 := ui.NewGrid()
 	grid1.SetPadded(true)
 	hbox.Append(grid1, false)
@@ -299,10 +700,10 @@ func (app *AppGUI) makeStatusPage() ui.Control {
 	grid3.SetPadded(true)
 	hbox.Append(grid3, false)
 
-	app.statusPage.TempA, app.statusPage.TempALabel = app.addProgressBarOnStatusPage(0, "Sensor A:", "0 °C", grid1)
-	app.statusPage.TempB, app.statusPage.TempBLabel = app.addProgressBarOnStatusPage(1, "Sensor B:", "0 °C", grid1)
-	app.statusPage.TempC, app.statusPage.TempCLabel = app.addProgressBarOnStatusPage(2, "Sensor C:", "0 °C", grid1)
-	app.statusPage.TempD, app.statusPage.TempDLabel = app.addProgressBarOnStatusPage(3, "Sensor D:", "0 °C", grid1)
+	app.statusPage.TempA, app.statusPage.TempALabel = app.addProgressBarOnStatusPage(0, "Sensor A:", "n/a", grid1)
+	app.statusPage.TempB, app.statusPage.TempBLabel = app.addProgressBarOnStatusPage(1, "Sensor B:", "n/a", grid1)
+	app.statusPage.TempC, app.statusPage.TempCLabel = app.addProgressBarOnStatusPage(2, "Sensor C:", "n/a", grid1)
+	app.statusPage.TempD, app.statusPage.TempDLabel = app.addProgressBarOnStatusPage(3, "Sensor D:", "n/a", grid1)
 
 	app.statusPage.Fan1A, app.statusPage.Fan1ALabel = app.addProgressBarOnStatusPage(0, "Fan 1A:", "0 RPM", grid2)
 	app.statusPage.Fan1B, app.statusPage.Fan1BLabel = app.addProgressBarOnStatusPage(1, "Fan 1B:", "0 RPM", grid2)
@@ -318,6 +719,18 @@ func (app *AppGUI) makeStatusPage() ui.Control {
 	app.statusPage.Output3, app.statusPage.Output3Label = app.addProgressBarOnStatusPage(2, "Output Fans 3A, 3B:", "0 %", grid3)
 	app.statusPage.Output4, app.statusPage.Output4Label = app.addProgressBarOnStatusPage(3, "Output Fans 4A, 4B:", "0 %", grid3)
 
+	if names := app.host.Names(); len(names) > 0 {
+		hbox.Append(ui.NewHorizontalSeparator(), false)
+		grid4 := ui.NewGrid()
+		grid4.SetPadded(true)
+		hbox.Append(grid4, false)
+		for i, name := range names {
+			progressBar, label := app.addProgressBarOnStatusPage(i, "Host "+name+":", "0 °C", grid4)
+			app.statusPage.Host = append(app.statusPage.Host, progressBar)
+			app.statusPage.HostLabels = append(app.statusPage.HostLabels, label)
+		}
+	}
+
 	return grid
 }
 
@@ -335,6 +748,10 @@ func (app *AppGUI) makeFansPage(fanPage *FanPage, fan int) ui.Control {
 
 	controlTypes := []string{"Sensor A", "Sensor B", "Sensor C", "Sensor D", "Sensor A - Sensor D", "Sensor B - Sensor D", "Sensor C - Sensor D", "Manual control"}
 	fanPage.Control = app.addComboBoxOnFanPage(2, 1, "Control:", controlTypes, grid1)
+	fanPage.Control.OnSelected(func(*ui.Combobox) {
+		app.UpdateActionButtons(true)
+		app.updateFanUnits()
+	})
 
 	grid2 := ui.NewGrid()
 	grid2.SetPadded(true)
@@ -348,10 +765,12 @@ func (app *AppGUI) makeFansPage(fanPage *FanPage, fan int) ui.Control {
 	grid2.Append(ui.NewLabel("%"), 2, 0, 1, 1, false, ui.AlignStart, false, ui.AlignCenter)
 	grid2.Append(ui.NewLabel("at"), 3, 0, 1, 1, false, ui.AlignEnd, false, ui.AlignCenter)
 	grid2.Append(fanPage.MinTemp, 4, 0, 1, 1, false, ui.AlignFill, false, ui.AlignCenter)
-	grid2.Append(ui.NewLabel("°C"), 5, 0, 1, 1, false, ui.AlignStart, false, ui.AlignCenter)
+	fanPage.MinTempUnit = ui.NewLabel("°C")
+	grid2.Append(fanPage.MinTempUnit, 5, 0, 1, 1, false, ui.AlignStart, false, ui.AlignCenter)
 	grid2.Append(ui.NewLabel("to 100% at"), 6, 0, 1, 1, false, ui.AlignFill, false, ui.AlignCenter)
 	grid2.Append(fanPage.MaxTemp, 7, 0, 1, 1, false, ui.AlignFill, false, ui.AlignCenter)
-	grid2.Append(ui.NewLabel("°C"), 8, 0, 1, 1, false, ui.AlignEnd, false, ui.AlignCenter)
+	fanPage.MaxTempUnit = ui.NewLabel("°C")
+	grid2.Append(fanPage.MaxTempUnit, 8, 0, 1, 1, false, ui.AlignEnd, false, ui.AlignCenter)
 
 	grid3 := ui.NewGrid()
 	grid3.SetPadded(false)
@@ -360,6 +779,10 @@ func (app *AppGUI) makeFansPage(fanPage *FanPage, fan int) ui.Control {
 	fanPage.AllowStop = app.addCheckBox("Completely stop the fan when the temperature is below the minimum")
 	grid3.Append(fanPage.AllowStop, 0, 0, 1, 1, false, ui.AlignFill, false, ui.AlignCenter)
 
+	if app.curves.PID(fan) != nil {
+		grid.Append(app.makePIDView(fanPage, fan), 0, 1, 1, 1, true, ui.AlignFill, true, ui.AlignFill)
+	}
+
 	return grid
 }
 
@@ -384,6 +807,7 @@ func (app *AppGUI) addComboBoxOnSensorsPage(index int, label string, items []str
 	combobox := ui.NewCombobox()
 	combobox.OnSelected(func(*ui.Combobox) {
 		app.UpdateActionButtons(true)
+		app.updateFanUnits()
 	})
 	for _, s := range items {
 		combobox.Append(s)
@@ -428,6 +852,7 @@ func (app *AppGUI) disableActionButtons() {
 }
 
 func (app *AppGUI) UpdateActionButtons(enable bool) {
+	app.edited = enable
 	if enable {
 		app.applyButton.Enable()
 		app.resetButton.Enable()
@@ -437,9 +862,9 @@ func (app *AppGUI) UpdateActionButtons(enable bool) {
 	}
 }
 
-func (app *AppGUI) updateTempOnStatusPage(progressBar *ui.ProgressBar, label *ui.Label, temp int8) {
-	progressBar.SetValue(app.tempToPerc(temp))
-	label.SetText(fmt.Sprintf("%d °C", temp))
+func (app *AppGUI) updateTempOnStatusPage(progressBar *ui.ProgressBar, label *ui.Label, temp int16, unit string) {
+	progressBar.SetValue(app.tempToPerc(int(temp)))
+	label.SetText(fmt.Sprintf("%d %s", temp, unit))
 }
 
 func (app *AppGUI) updateRPMOnStatusPage(progressBar *ui.ProgressBar, label *ui.Label, rpm int16) {
@@ -452,29 +877,30 @@ func (app *AppGUI) updateOutputOnStatusPage(progressBar *ui.ProgressBar, label *
 	label.SetText(fmt.Sprintf("%d %s", output, "%"))
 }
 
+// UpdateStatusPage and UpdateConfigPages must run on the UI thread.
 func (app *AppGUI) UpdateStatusPage() {
 	status := app.serial.GetStatus()
 	config := app.serial.GetConfig()
 
 	if config.SensorTypes.SensorTypeA != SENSOR_NOT_CONNECTED {
-		app.updateTempOnStatusPage(app.statusPage.TempA, app.statusPage.TempALabel, status.Temperatures.SensorA)
+		app.updateTempOnStatusPage(app.statusPage.TempA, app.statusPage.TempALabel, status.Temperatures.SensorA, config.SensorUnit(SENSOR_A))
 	} else {
-		app.updateTempOnStatusPage(app.statusPage.TempA, app.statusPage.TempALabel, 0)
+		app.updateTempOnStatusPage(app.statusPage.TempA, app.statusPage.TempALabel, 0, config.SensorUnit(SENSOR_A))
 	}
 	if config.SensorTypes.SensorTypeB != SENSOR_NOT_CONNECTED {
-		app.updateTempOnStatusPage(app.statusPage.TempB, app.statusPage.TempBLabel, status.Temperatures.SensorB)
+		app.updateTempOnStatusPage(app.statusPage.TempB, app.statusPage.TempBLabel, status.Temperatures.SensorB, config.SensorUnit(SENSOR_B))
 	} else {
-		app.updateTempOnStatusPage(app.statusPage.TempB, app.statusPage.TempBLabel, 0)
+		app.updateTempOnStatusPage(app.statusPage.TempB, app.statusPage.TempBLabel, 0, config.SensorUnit(SENSOR_B))
 	}
 	if config.SensorTypes.SensorTypeC != SENSOR_NOT_CONNECTED {
-		app.updateTempOnStatusPage(app.statusPage.TempC, app.statusPage.TempCLabel, status.Temperatures.SensorC)
+		app.updateTempOnStatusPage(app.statusPage.TempC, app.statusPage.TempCLabel, status.Temperatures.SensorC, config.SensorUnit(SENSOR_C))
 	} else {
-		app.updateTempOnStatusPage(app.statusPage.TempC, app.statusPage.TempCLabel, 0)
+		app.updateTempOnStatusPage(app.statusPage.TempC, app.statusPage.TempCLabel, 0, config.SensorUnit(SENSOR_C))
 	}
 	if config.SensorTypes.SensorTypeD != SENSOR_NOT_CONNECTED {
-		app.updateTempOnStatusPage(app.statusPage.TempD, app.statusPage.TempDLabel, status.Temperatures.SensorD)
+		app.updateTempOnStatusPage(app.statusPage.TempD, app.statusPage.TempDLabel, status.Temperatures.SensorD, config.SensorUnit(SENSOR_D))
 	} else {
-		app.updateTempOnStatusPage(app.statusPage.TempD, app.statusPage.TempDLabel, 0)
+		app.updateTempOnStatusPage(app.statusPage.TempD, app.statusPage.TempDLabel, 0, config.SensorUnit(SENSOR_D))
 	}
 	if config.Fan1Config.FanTypeA != FAN_NOT_CONNECTED {
 		app.updateRPMOnStatusPage(app.statusPage.Fan1A, app.statusPage.Fan1ALabel, status.RPMS.Fan1A)
@@ -520,6 +946,19 @@ func (app *AppGUI) UpdateStatusPage() {
 	app.updateOutputOnStatusPage(app.statusPage.Output2, app.statusPage.Output2Label, status.Outputs.Fan2)
 	app.updateOutputOnStatusPage(app.statusPage.Output3, app.statusPage.Output3Label, status.Outputs.Fan3)
 	app.updateOutputOnStatusPage(app.statusPage.Output4, app.statusPage.Output4Label, status.Outputs.Fan4)
+
+	app.hostLock.Lock()
+	temps, valid := app.hostTemps, app.hostValid
+	app.hostLock.Unlock()
+	for i := range app.statusPage.Host {
+		if i < len(valid) && valid[i] {
+			app.statusPage.Host[i].SetValue(app.tempToPerc(temps[i]))
+			app.statusPage.HostLabels[i].SetText(fmt.Sprintf("%d °C", temps[i]))
+		} else {
+			app.statusPage.Host[i].SetValue(0)
+			app.statusPage.HostLabels[i].SetText("n/a")
+		}
+	}
 }
 
 func (app *AppGUI) UpdateConfigPages() {
@@ -533,21 +972,31 @@ func (app *AppGUI) UpdateConfigPages() {
 	app.updateConfigPage(&config.Fan2Config, &app.fan2Page)
 	app.updateConfigPage(&config.Fan3Config, &app.fan3Page)
 	app.updateConfigPage(&config.Fan4Config, &app.fan4Page)
+	app.updateFanUnits()
 
 	app.UpdateActionButtons(false)
 }
 
+// CloseMainWindow releases the fan curves and closes the connection. That
+// waits for a running reconnect attempt and the fallback reply, so it's done
+// off the UI thread.
 func (app *AppGUI) CloseMainWindow(selectPort bool) {
-	app.serial.StopRead()
+	go func() {
+		app.reconnect.Stop()
+		app.curves.Release()
+		app.serial.StopRead()
+		app.alerts.Clear("link")
 
-	if selectPort {
-		app.showSelectPortWindow()
-	} else {
-		ui.QueueMain(func() {
-			ui.Quit()
-			os.Exit(0)
-		})
-	}
+		if selectPort {
+			app.showSelectPortWindow()
+		} else {
+			app.history.Close()
+			ui.QueueMain(func() {
+				ui.Quit()
+				os.Exit(0)
+			})
+		}
+	}()
 }
 
 func (app *AppGUI) showSelectPortWindow() {
@@ -558,6 +1007,7 @@ func (app *AppGUI) showSelectPortWindow() {
 		if app.mainWindow != nil {
 			app.mainWindow.Hide()
 		}
+		app.setVisible(false)
 		if app.selectPortWindow != nil {
 			app.selectPortWindow.Show()
 		}
@@ -572,6 +1022,7 @@ func (app *AppGUI) hideMainWindow() {
 		if app.mainWindow != nil {
 			app.mainWindow.Hide()
 		}
+		app.setVisible(false)
 	})
 }
 
@@ -582,6 +1033,7 @@ func (app *AppGUI) showMainWindow() {
 		}
 		if app.mainWindow != nil {
 			app.mainWindow.Show()
+			app.setVisible(true)
 		}
 		if app.selectPortWindow != nil {
 			app.selectPortWindow.Hide()
@@ -599,6 +1051,20 @@ func (app *AppGUI) updateConfigPage(fanConfig *FanConfig, fanPage *FanPage) {
 	fanPage.AllowStop.SetChecked(fanConfig.AllowStopped)
 }
 
+// updateFanUnits shows the unit of the controlling sensor selected in the
+// window next to the temperatures of the fan pages.
+func (app *AppGUI) updateFanUnits() {
+	config := app.getConfig()
+	for _, fanPage := range []*FanPage{&app.fan1Page, &app.fan2Page, &app.fan3Page, &app.fan4Page} {
+		if fanPage.MinTempUnit == nil {
+			continue
+		}
+		unit := config.ControlUnit(int8(fanPage.Control.Selected()))
+		fanPage.MinTempUnit.SetText(unit)
+		fanPage.MaxTempUnit.SetText(unit)
+	}
+}
+
 func (app *AppGUI) getFanConfig(fanPage *FanPage) FanConfig {
 	return FanConfig{
 		MinimumPower:       int8(fanPage.Power.Value()),
@@ -627,8 +1093,8 @@ func (app *AppGUI) getConfig() *Config {
 	return config
 }
 
-func (app *AppGUI) tempToPerc(temp int8) int {
-	if int(temp) > app.appConfig.MaxTemp {
+func (app *AppGUI) tempToPerc(temp int) int {
+	if temp > app.appConfig.MaxTemp {
 		return 100
 	}
 	return int(float64(temp) * 100.0 / float64(app.appConfig.MaxTemp))
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HISTORY_INTERVAL       = 10
+	HISTORY_RETENTION_DAYS = 30
+	HISTORY_DAY_FORMAT     = "2006-01-02"
+	HISTORY_EXT            = ".csv"
+)
+
+// HistorySample is the average of the status frames received during one
+// history interval.
+type HistorySample struct {
+	Time    time.Time
+	Temps   [SENSOR_COUNT]float64
+	Outputs [FAN_COUNT]float64
+	RPMs    [FAN_COUNT * 2]float64
+}
+
+func (s *HistorySample) values() []float64 {
+	values := make([]float64, 0, SENSOR_COUNT+FAN_COUNT*3)
+	values = append(values, s.Temps[:]...)
+	values = append(values, s.Outputs[:]...)
+	return append(values, s.RPMs[:]...)
+}
+
+func (s *HistorySample) setValues(values []float64) {
+	copy(s.Temps[:], values)
+	copy(s.Outputs[:], values[SENSOR_COUNT:])
+	copy(s.RPMs[:], values[SENSOR_COUNT+FAN_COUNT:])
+}
+
+func sampleFromStatus(status *Status) HistorySample {
+	var s HistorySample
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		s.Temps[sensor] = float64(status.Temperature(sensor))
+	}
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		rpmA, rpmB := status.FanRPM(fan)
+		s.Outputs[fan-1] = float64(status.FanOutput(fan))
+		s.RPMs[(fan-1)*2] = float64(rpmA)
+		s.RPMs[(fan-1)*2+1] = float64(rpmB)
+	}
+	return s
+}
+
+func historyDir() string {
+	return filepath.Join(filepath.Dir(APP_CONFIG), "history")
+}
+
+// History keeps status samples in one CSV file per day, every line is the
+// unix time followed by temperatures, outputs and RPMs.
+type History struct {
+	interval  time.Duration
+	retention int
+
+	lock    sync.Mutex
+	sum     []float64
+	count   int
+	started time.Time
+	file    *os.File
+	day     string
+}
+
+func NewHistory(appConfig *AppConfig) *History {
+	return &History{
+		interval:  time.Duration(appConfig.HistoryInterval) * time.Second,
+		retention: appConfig.HistoryRetentionDays,
+	}
+}
+
+func (h *History) Enabled() bool {
+	return h.retention > 0 && h.interval > 0
+}
+
+func (h *History) OnEvent(ev Event) {
+	switch ev := ev.(type) {
+	case StatusReceived:
+		h.OnStatus(ev.Status)
+	case Disconnected, Closed:
+		h.lock.Lock()
+		h.count = 0
+		h.lock.Unlock()
+	}
+}
+
+func (h *History) OnStatus(status Status) {
+	if !h.Enabled() {
+		return
+	}
+	now := time.Now()
+	current := sampleFromStatus(&status)
+	values := current.values()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.count == 0 {
+		h.sum = make([]float64, len(values))
+		h.started = now
+	}
+	for i, v := range values {
+		h.sum[i] += v
+	}
+	h.count++
+	if now.Sub(h.started) < h.interval {
+		return
+	}
+
+	for i := range h.sum {
+		h.sum[i] /= float64(h.count)
+	}
+	sample := HistorySample{Time: now}
+	sample.setValues(h.sum)
+	h.count = 0
+	if err := h.write(&sample); err != nil {
+		log.Printf("err=%v", err)
+	}
+}
+
+func (h *History) write(sample *HistorySample) error {
+	day := sample.Time.Format(HISTORY_DAY_FORMAT)
+	if h.file == nil || h.day != day {
+		if h.file != nil {
+			h.file.Close()
+			h.file = nil
+		}
+		if err := os.MkdirAll(historyDir(), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(historyDir(), day+HISTORY_EXT), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		h.file = f
+		h.day = day
+		h.cleanup(sample.Time)
+	}
+
+	line := strconv.FormatInt(sample.Time.Unix(), 10)
+	for _, v := range sample.values() {
+		line += "," + strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	_, err := h.file.WriteString(line + "\n")
+	return err
+}
+
+// cleanup removes day files older than the retention.
+func (h *History) cleanup(now time.Time) {
+	oldest := now.AddDate(0, 0, -h.retention).Format(HISTORY_DAY_FORMAT)
+	files, err := ioutil.ReadDir(historyDir())
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		day := strings.TrimSuffix(f.Name(), HISTORY_EXT)
+		if strings.HasSuffix(f.Name(), HISTORY_EXT) && day < oldest {
+			os.Remove(filepath.Join(historyDir(), f.Name()))
+		}
+	}
+}
+
+func (h *History) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+}
+
+func parseHistoryLine(line string) (HistorySample, error) {
+	var sample HistorySample
+	sp := strings.Split(line, ",")
+	values := make([]float64, len(sp)-1)
+	if len(values) != len(sample.values()) {
+		return sample, fmt.Errorf("Invalid history line %q", line)
+	}
+	t, err := strconv.ParseInt(sp[0], 10, 64)
+	if err != nil {
+		return sample, err
+	}
+	for i, s := range sp[1:] {
+		if values[i], err = strconv.ParseFloat(s, 64); err != nil {
+			return sample, err
+		}
+	}
+	sample.Time = time.Unix(t, 0)
+	sample.setValues(values)
+	return sample, nil
+}
+
+// Query returns samples between from and to averaged into at most buckets
+// points, buckets without samples are skipped.
+func (h *History) Query(from, to time.Time, buckets int) []HistorySample {
+	width := to.Sub(from) / time.Duration(buckets)
+	if width <= 0 {
+		width = time.Second
+	}
+	sums := make([][]float64, buckets)
+	counts := make([]int, buckets)
+
+	// day files are named by the local date of their samples
+	from, to = from.Local(), to.Local()
+	last := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.Local)
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local); !day.After(last); day = day.AddDate(0, 0, 1) {
+		f, err := os.Open(filepath.Join(historyDir(), day.Format(HISTORY_DAY_FORMAT)+HISTORY_EXT))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			sample, err := parseHistoryLine(scanner.Text())
+			if err != nil || sample.Time.Before(from) || sample.Time.After(to) {
+				continue
+			}
+			b := int(sample.Time.Sub(from) / width)
+			if b >= buckets {
+				b = buckets - 1
+			}
+			values := sample.values()
+			if sums[b] == nil {
+				sums[b] = make([]float64, len(values))
+			}
+			for i, v := range values {
+				sums[b][i] += v
+			}
+			counts[b]++
+		}
+		f.Close()
+	}
+
+	samples := make([]HistorySample, 0, buckets)
+	for b := range sums {
+		if counts[b] == 0 {
+			continue
+		}
+		for i := range sums[b] {
+			sums[b][i] /= float64(counts[b])
+		}
+		sample := HistorySample{Time: from.Add(width*time.Duration(b) + width/2)}
+		sample.setValues(sums[b])
+		samples = append(samples, sample)
+	}
+	return samples
+}
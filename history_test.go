@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func historySample(t time.Time, temp float64) HistorySample {
+	sample := HistorySample{Time: t}
+	sample.Temps[SENSOR_A] = temp
+	sample.RPMs[1] = temp * 10
+	return sample
+}
+
+func TestHistoryQuery(t *testing.T) {
+	inTempDir(t)
+	h := NewHistory(&AppConfig{HistoryInterval: 10, HistoryRetentionDays: 30})
+	defer h.Close()
+
+	// samples around midnight go to two day files
+	midnight := time.Date(2024, 3, 10, 0, 0, 0, 0, time.Local)
+	for i, temp := range []float64{20, 22, 30, 34, 40} {
+		sample := historySample(midnight.Add(time.Minute*time.Duration(i*10-20)), temp)
+		if err := h.write(&sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := ioutil.ReadDir(historyDir())
+	if len(files) != 2 {
+		t.Fatalf("%d day files", len(files))
+	}
+
+	tests := []struct {
+		from, to time.Duration
+		buckets  int
+		temps    []float64
+	}{
+		{-time.Minute * 20, time.Minute * 20, 1, []float64{29.2}},
+		{-time.Minute * 20, time.Minute * 20, 2, []float64{21, 34.666666666666664}},
+		{-time.Minute * 20, time.Minute * 20, 4, []float64{20, 22, 30, 37}},
+		{-time.Minute * 30, time.Minute * 10, 4, []float64{20, 22, 32}},
+		{time.Minute * 5, time.Minute * 45, 4, []float64{34, 40}},
+		{time.Hour, time.Hour * 2, 4, []float64{}},
+	}
+	for _, test := range tests {
+		samples := h.Query(midnight.Add(test.from), midnight.Add(test.to), test.buckets)
+		temps := make([]float64, len(samples))
+		for i, sample := range samples {
+			temps[i] = sample.Temps[SENSOR_A]
+			if math.Abs(sample.RPMs[1]-temps[i]*10) > 1e-9 {
+				t.Errorf("RPM %v of temperature %v", sample.RPMs[1], temps[i])
+			}
+		}
+		if ToJSON(temps) != ToJSON(test.temps) {
+			t.Errorf("%v-%v in %d buckets: %v, want %v", test.from, test.to, test.buckets, temps, test.temps)
+		}
+	}
+}
+
+func TestHistoryAverage(t *testing.T) {
+	inTempDir(t)
+	h := NewHistory(&AppConfig{HistoryInterval: 60, HistoryRetentionDays: 1})
+	defer h.Close()
+
+	status := func(temp int16) Status {
+		return Status{Temperatures: Temperatures{SensorA: temp}}
+	}
+	h.OnStatus(status(99))
+	h.OnEvent(Disconnected{})
+	from := time.Now().Add(-time.Minute)
+	for _, temp := range []int16{20, 21, 25} {
+		h.lock.Lock()
+		h.started = h.started.Add(-time.Second * 30)
+		h.lock.Unlock()
+		h.OnStatus(status(temp))
+	}
+	samples := h.Query(from, time.Now().Add(time.Second), 1)
+	if len(samples) != 1 || samples[0].Temps[SENSOR_A] != 22 {
+		t.Fatalf("samples %+v", samples)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	inTempDir(t)
+	if err := os.MkdirAll(historyDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"2024-02-08.csv", "2024-02-09.csv", "2024-02-10.csv", "2024-01-01.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(historyDir(), name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewHistory(&AppConfig{HistoryInterval: 10, HistoryRetentionDays: 30})
+	defer h.Close()
+	sample := historySample(time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local), 20)
+	if err := h.write(&sample); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	files, _ := ioutil.ReadDir(historyDir())
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if ToJSON(names) != `["2024-01-01.txt","2024-02-09.csv","2024-02-10.csv","2024-03-10.csv"]` {
+		t.Fatalf("files after cleanup %v", names)
+	}
+}
+
+func TestParseHistoryLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		valid bool
+	}{
+		{"1710000000,20.0,77.0,0.0,26.0,30.0,0.0,50.0,30.0,593.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0", true},
+		{"1710000000,20.0,77.0", false},
+		{"1710000000,20.0,77.0,0.0,26.0,30.0,0.0,50.0,30.0,593.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,1.0", false},
+		{"x,20.0,77.0,0.0,26.0,30.0,0.0,50.0,30.0,593.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0", false},
+		{"1710000000,20.0,77.0,0.0,26.0,30.0,0.0,50.0,30.0,593.0,0.0,0.0,0.0,0.0,0.0,0.0,", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		sample, err := parseHistoryLine(test.line)
+		if (err == nil) != test.valid {
+			t.Errorf("%q err=%v", test.line, err)
+		} else if test.valid && (sample.Time.Unix() != 1710000000 || sample.RPMs[0] != 593) {
+			t.Errorf("%q = %+v", test.line, sample)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+//go:build !nogui
+// +build !nogui
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andlabs/ui"
+)
+
+const (
+	HISTORY_REFRESH = time.Second * 30
+	HISTORY_POINTS  = 300
+)
+
+var historyWindows = []struct {
+	Name   string
+	Length time.Duration
+}{
+	{"15 min", time.Minute * 15},
+	{"1 h", time.Hour},
+	{"24 h", time.Hour * 24},
+	{"7 d", time.Hour * 24 * 7},
+}
+
+// colors of the series, the same color is used for sensor A, fans 1 and
+// fan 1A, B and 1B is drawn dashed
+var historyColors = [][3]float64{
+	{0.85, 0.15, 0.15},
+	{0.15, 0.6, 0.15},
+	{0.15, 0.3, 0.85},
+	{0.9, 0.55, 0.0},
+}
+
+type HistoryPage struct {
+	Window                           *ui.Combobox
+	Temps, Outputs, RPMs             *HistoryChart
+	TempsArea, OutputsArea, RPMsArea *ui.Area
+	refresh                          chan struct{}
+}
+
+// chartNoInput implements the input methods of ui.AreaHandler for charts.
+type chartNoInput struct{}
+
+func (chartNoInput) MouseEvent(a *ui.Area, me *ui.AreaMouseEvent) {
+}
+
+func (chartNoInput) MouseCrossed(a *ui.Area, left bool) {
+}
+
+func (chartNoInput) DragBroken(a *ui.Area) {
+}
+
+func (chartNoInput) KeyEvent(a *ui.Area, ke *ui.AreaKeyEvent) bool {
+	return false
+}
+
+// drawChartGrid fills the background and draws horizontal lines at every
+// quarter.
+func drawChartGrid(p *ui.AreaDrawParams) {
+	w, h := p.AreaWidth, p.AreaHeight
+
+	bg := ui.DrawNewPath(ui.DrawFillModeWinding)
+	bg.AddRectangle(0, 0, w, h)
+	bg.End()
+	p.Context.Fill(bg, &ui.DrawBrush{Type: ui.DrawBrushTypeSolid, R: 1, G: 1, B: 1, A: 1})
+	bg.Free()
+
+	grid := ui.DrawNewPath(ui.DrawFillModeWinding)
+	for i := 0; i <= 4; i++ {
+		y := h * float64(i) / 4
+		grid.NewFigure(0, y)
+		grid.LineTo(w, y)
+	}
+	grid.End()
+	p.Context.Stroke(grid, &ui.DrawBrush{Type: ui.DrawBrushTypeSolid, R: 0.8, G: 0.8, B: 0.8, A: 1},
+		&ui.DrawStrokeParams{Thickness: 1, MiterLimit: ui.DrawDefaultMiterLimit})
+	grid.Free()
+}
+
+// strokeChartPath draws and frees the ended path.
+func strokeChartPath(p *ui.AreaDrawParams, path *ui.DrawPath, color [3]float64, dashed bool) {
+	params := &ui.DrawStrokeParams{
+		Cap:        ui.DrawLineCapRound,
+		Join:       ui.DrawLineJoinRound,
+		Thickness:  1.5,
+		MiterLimit: ui.DrawDefaultMiterLimit,
+	}
+	if dashed {
+		params.Dashes = []float64{4, 3}
+	}
+	p.Context.Stroke(path, &ui.DrawBrush{Type: ui.DrawBrushTypeSolid, R: color[0], G: color[1], B: color[2], A: 1}, params)
+	path.Free()
+}
+
+// HistoryChart draws lines of one group of values from HistorySample.
+type HistoryChart struct {
+	chartNoInput
+	max      float64
+	values   func(s *HistorySample) []float64
+	dashed   func(i int) bool
+	color    func(i int) [3]float64
+	from, to time.Time
+	samples  []HistorySample
+}
+
+func (c *HistoryChart) Draw(a *ui.Area, p *ui.AreaDrawParams) {
+	w, h := p.AreaWidth, p.AreaHeight
+	drawChartGrid(p)
+
+	span := c.to.Sub(c.from)
+	if len(c.samples) == 0 || span <= 0 || c.max <= 0 {
+		return
+	}
+	gap := span / HISTORY_POINTS * 3
+	x := func(t time.Time) float64 {
+		return w * float64(t.Sub(c.from)) / float64(span)
+	}
+	y := func(v float64) float64 {
+		if v > c.max {
+			v = c.max
+		} else if v < 0 {
+			v = 0
+		}
+		return h - h*v/c.max
+	}
+
+	for i := range c.values(&c.samples[0]) {
+		path := ui.DrawNewPath(ui.DrawFillModeWinding)
+		for j := range c.samples {
+			v := c.values(&c.samples[j])[i]
+			if j == 0 || c.samples[j].Time.Sub(c.samples[j-1].Time) > gap {
+				path.NewFigure(x(c.samples[j].Time), y(v))
+			} else {
+				path.LineTo(x(c.samples[j].Time), y(v))
+			}
+		}
+		path.End()
+		strokeChartPath(p, path, c.color(i), c.dashed(i))
+	}
+}
+
+func (app *AppGUI) addHistoryChart(index int, title, legend string, chart *HistoryChart, grid *ui.Grid) *ui.Area {
+	area := ui.NewArea(chart)
+	grid.Append(ui.NewLabel(title), 0, index*2, 1, 1, false, ui.AlignStart, false, ui.AlignCenter)
+	grid.Append(ui.NewLabel(legend), 1, index*2, 1, 1, true, ui.AlignEnd, false, ui.AlignCenter)
+	grid.Append(area, 0, index*2+1, 2, 1, true, ui.AlignFill, true, ui.AlignFill)
+	return area
+}
+
+func (app *AppGUI) makeHistoryPage() ui.Control {
+	page := &app.historyPage
+	page.refresh = make(chan struct{}, 1)
+
+	grid := ui.NewGrid()
+	grid.SetPadded(true)
+
+	grid1 := ui.NewGrid()
+	grid1.SetPadded(true)
+	grid.Append(grid1, 0, 0, 2, 1, false, ui.AlignStart, false, ui.AlignCenter)
+	grid1.Append(ui.NewLabel("Show last:"), 0, 0, 1, 1, false, ui.AlignEnd, false, ui.AlignCenter)
+	page.Window = ui.NewCombobox()
+	for _, w := range historyWindows {
+		page.Window.Append(w.Name)
+	}
+	page.Window.SetSelected(0)
+	page.Window.OnSelected(func(*ui.Combobox) {
+		app.refreshHistory()
+	})
+	grid1.Append(page.Window, 1, 0, 1, 1, false, ui.AlignStart, false, ui.AlignCenter)
+
+	never := func(i int) bool { return false }
+	odd := func(i int) bool { return i%2 == 1 }
+	page.Temps = &HistoryChart{
+		max:    float64(app.appConfig.MaxTemp),
+		values: func(s *HistorySample) []float64 { return s.Temps[:] },
+		dashed: never,
+		color:  func(i int) [3]float64 { return historyColors[i] },
+	}
+	page.Outputs = &HistoryChart{
+		max:    100,
+		values: func(s *HistorySample) []float64 { return s.Outputs[:] },
+		dashed: never,
+		color:  func(i int) [3]float64 { return historyColors[i] },
+	}
+	page.RPMs = &HistoryChart{
+		max:    float64(app.appConfig.MaxRPM),
+		values: func(s *HistorySample) []float64 { return s.RPMs[:] },
+		dashed: odd,
+		color:  func(i int) [3]float64 { return historyColors[i/2] },
+	}
+
+	charts := ui.NewGrid()
+	charts.SetPadded(true)
+	grid.Append(charts, 0, 1, 2, 1, true, ui.AlignFill, true, ui.AlignFill)
+	page.TempsArea = app.addHistoryChart(0, fmt.Sprintf("Temperatures, 0 - %d °C", app.appConfig.MaxTemp),
+		"A red, B green, C blue, D orange, °F sensors converted", page.Temps, charts)
+	page.OutputsArea = app.addHistoryChart(1, "Outputs, 0 - 100 %",
+		"Fans 1 red, 2 green, 3 blue, 4 orange", page.Outputs, charts)
+	page.RPMsArea = app.addHistoryChart(2, fmt.Sprintf("Fan speed, 0 - %d RPM", app.appConfig.MaxRPM),
+		"Fans A solid, B dashed", page.RPMs, charts)
+
+	go app.runHistoryRefresh()
+	return grid
+}
+
+// historyToCelsius converts the temperatures of °F sensors, the samples are
+// stored in the unit of the sensor and the chart is in °C.
+func historyToCelsius(samples []HistorySample, config *Config) {
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		if config.SensorType(sensor) != SENSOR_TYPE_F {
+			continue
+		}
+		for i := range samples {
+			samples[i].Temps[sensor] = (samples[i].Temps[sensor] - 32) * 5 / 9
+		}
+	}
+}
+
+// refreshHistory requests a reload of the charts, it's called on the UI
+// thread.
+func (app *AppGUI) refreshHistory() {
+	select {
+	case app.historyPage.refresh <- struct{}{}:
+	default:
+	}
+}
+
+func (app *AppGUI) runHistoryRefresh() {
+	page := &app.historyPage
+	ticker := time.NewTicker(HISTORY_REFRESH)
+	defer ticker.Stop()
+
+	window := make(chan time.Duration)
+	for {
+		// setVisible refreshes once the window is shown again
+		if !app.isVisible() {
+			select {
+			case <-ticker.C:
+			case <-page.refresh:
+			}
+			continue
+		}
+		ui.QueueMain(func() {
+			selected := page.Window.Selected()
+			if selected < 0 {
+				selected = 0
+			}
+			window <- historyWindows[selected].Length
+		})
+		length := <-window
+		to := time.Now()
+		from := to.Add(-length)
+		var samples []HistorySample
+		if app.history != nil && app.history.Enabled() {
+			samples = app.history.Query(from, to, HISTORY_POINTS)
+			config := app.serial.GetConfig()
+			historyToCelsius(samples, &config)
+		}
+
+		ui.QueueMain(func() {
+			for _, chart := range []*HistoryChart{page.Temps, page.Outputs, page.RPMs} {
+				chart.from, chart.to, chart.samples = from, to, samples
+			}
+			page.TempsArea.QueueRedrawAll()
+			page.OutputsArea.QueueRedrawAll()
+			page.RPMsArea.QueueRedrawAll()
+		})
+
+		select {
+		case <-ticker.C:
+		case <-page.refresh:
+		}
+	}
+}
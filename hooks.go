@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HOOK_TIMEOUT = time.Second * 30
+
+	HOOK_CONNECTED    = "connected"
+	HOOK_DISCONNECTED = "disconnected"
+	HOOK_APPLIED      = "applied"
+	HOOK_REJECTED     = "rejected"
+	HOOK_TEMPERATURE  = "temperature"
+	HOOK_STALE        = "stale"
+)
+
+// Hook runs Command on Event, temperature hooks fire when Sensor rises to
+// Limit and when it drops TempAlarmHysteresis below it again.
+type Hook struct {
+	Event   string
+	Command []string
+	Sensor  string
+	Limit   int
+}
+
+// HookEvent is passed to the command as JSON on stdin and as FANCONTROLLER_*
+// environment variables.
+type HookEvent struct {
+	Event       string
+	Time        time.Time
+	Port        string
+	Message     string `json:",omitempty"`
+	Sensor      string `json:",omitempty"`
+	Temperature int    `json:",omitempty"`
+	Limit       int    `json:",omitempty"`
+	Direction   string `json:",omitempty"`
+}
+
+func (e *HookEvent) env() []string {
+	env := []string{
+		"FANCONTROLLER_EVENT=" + e.Event,
+		"FANCONTROLLER_TIME=" + e.Time.Format(time.RFC3339),
+		"FANCONTROLLER_PORT=" + e.Port,
+		"FANCONTROLLER_MESSAGE=" + e.Message,
+	}
+	if e.Sensor != "" {
+		env = append(env,
+			"FANCONTROLLER_SENSOR="+e.Sensor,
+			"FANCONTROLLER_TEMPERATURE="+strconv.Itoa(e.Temperature),
+			"FANCONTROLLER_LIMIT="+strconv.Itoa(e.Limit),
+			"FANCONTROLLER_DIRECTION="+e.Direction)
+	}
+	return env
+}
+
+// Hooks runs the commands configured in AppConfig.Hooks on controller
+// events.
+type Hooks struct {
+	hooks []Hook
+	hyst  int
+
+	lock   sync.Mutex
+	port   string
+	config Config
+	above  []bool
+}
+
+func NewHooks(appConfig *AppConfig) *Hooks {
+	h := &Hooks{hyst: appConfig.TempAlarmHysteresis}
+	for _, hook := range appConfig.Hooks {
+		if len(hook.Command) == 0 {
+			log.Printf("Hook for %q has no command", hook.Event)
+			continue
+		}
+		switch hook.Event {
+		case HOOK_CONNECTED, HOOK_DISCONNECTED, HOOK_APPLIED, HOOK_REJECTED, HOOK_STALE:
+		case HOOK_TEMPERATURE:
+			if sensorIndex(hook.Sensor) < 0 {
+				log.Printf("Invalid sensor %q in hook %q", hook.Sensor, strings.Join(hook.Command, " "))
+				continue
+			}
+		default:
+			log.Printf("Invalid hook event %q", hook.Event)
+			continue
+		}
+		h.hooks = append(h.hooks, hook)
+	}
+	h.above = make([]bool, len(h.hooks))
+	return h
+}
+
+func sensorIndex(name string) int {
+	for i, v := range sensorNames {
+		if strings.EqualFold(v, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Hooks) fire(event HookEvent) {
+	event.Time = time.Now()
+	h.lock.Lock()
+	event.Port = h.port
+	h.lock.Unlock()
+	for _, hook := range h.hooks {
+		if hook.Event == event.Event && event.Event != HOOK_TEMPERATURE {
+			go runHook(hook, event)
+		}
+	}
+}
+
+func runHook(hook Hook, event HookEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), HOOK_TIMEOUT)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(), event.env()...)
+	cmd.Stdin = strings.NewReader(ToJSON(event))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("Hook %q for %s err=%v output=%q", strings.Join(hook.Command, " "), event.Event, err, out)
+	} else if DEBUG_INFO {
+		log.Printf("Hook %q for %s output=%q", strings.Join(hook.Command, " "), event.Event, out)
+	}
+}
+
+func (h *Hooks) OnEvent(ev Event) {
+	switch ev := ev.(type) {
+	case Connected:
+		h.lock.Lock()
+		h.port = ev.Port
+		h.lock.Unlock()
+		h.fire(HookEvent{Event: HOOK_CONNECTED})
+	case StatusReceived:
+		h.OnStatus(ev.Status)
+	case ConfigReceived:
+		h.lock.Lock()
+		h.config = ev.Config
+		h.lock.Unlock()
+	case ApplySucceeded:
+		h.fire(HookEvent{Event: HOOK_APPLIED})
+	case ApplyFailed:
+		h.fire(HookEvent{Event: HOOK_REJECTED, Message: ev.Message})
+	case Disconnected:
+		msg := ""
+		if ev.Err != nil {
+			msg = ev.Err.Error()
+		}
+		h.fire(HookEvent{Event: HOOK_DISCONNECTED, Message: msg})
+	case LinkStale:
+		h.fire(HookEvent{Event: HOOK_STALE, Message: "No data since " + ev.Last.Format(time.RFC3339)})
+	}
+}
+
+func (h *Hooks) OnStatus(status Status) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, hook := range h.hooks {
+		if hook.Event != HOOK_TEMPERATURE {
+			continue
+		}
+		sensor := sensorIndex(hook.Sensor)
+		if h.config.SensorType(sensor) == SENSOR_NOT_CONNECTED {
+			continue
+		}
+		temp := int(status.Temperature(sensor))
+		direction := ""
+		if !h.above[i] && temp >= hook.Limit {
+			direction = "above"
+		} else if h.above[i] && temp < hook.Limit-h.hyst {
+			direction = "below"
+		}
+		if direction == "" {
+			continue
+		}
+		h.above[i] = direction == "above"
+		go runHook(hook, HookEvent{
+			Event:       HOOK_TEMPERATURE,
+			Time:        time.Now(),
+			Port:        h.port,
+			Sensor:      sensorNames[sensor],
+			Temperature: temp,
+			Limit:       hook.Limit,
+			Direction:   direction,
+		})
+	}
+}
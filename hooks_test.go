@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookEventEnv(t *testing.T) {
+	at := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		event HookEvent
+		env   string
+	}{
+		{HookEvent{Event: HOOK_CONNECTED, Time: at, Port: "/dev/ttyUSB0"},
+			"FANCONTROLLER_EVENT=connected FANCONTROLLER_TIME=2024-03-10T12:00:00Z FANCONTROLLER_PORT=/dev/ttyUSB0 FANCONTROLLER_MESSAGE="},
+		{HookEvent{Event: HOOK_REJECTED, Time: at, Message: "Invalid config"},
+			"FANCONTROLLER_EVENT=rejected FANCONTROLLER_TIME=2024-03-10T12:00:00Z FANCONTROLLER_PORT= FANCONTROLLER_MESSAGE=Invalid config"},
+		{HookEvent{Event: HOOK_TEMPERATURE, Time: at, Sensor: "B", Temperature: 0, Limit: 40, Direction: "below"},
+			"FANCONTROLLER_EVENT=temperature FANCONTROLLER_TIME=2024-03-10T12:00:00Z FANCONTROLLER_PORT= FANCONTROLLER_MESSAGE= " +
+				"FANCONTROLLER_SENSOR=B FANCONTROLLER_TEMPERATURE=0 FANCONTROLLER_LIMIT=40 FANCONTROLLER_DIRECTION=below"},
+	}
+	for _, test := range tests {
+		if env := strings.Join(test.event.env(), " "); env != test.env {
+			t.Errorf("env %s, want %s", env, test.env)
+		}
+	}
+
+	var event HookEvent
+	if err := json.Unmarshal([]byte(ToJSON(tests[0].event)), &event); err != nil || event != tests[0].event {
+		t.Errorf("JSON %s err=%v", ToJSON(tests[0].event), err)
+	}
+	if s := ToJSON(tests[0].event); strings.Contains(s, "Sensor") || strings.Contains(s, "Message") {
+		t.Errorf("JSON %s has empty fields", s)
+	}
+}
+
+// waitLines waits until path has n lines and returns them.
+func waitLines(t *testing.T, path string, n int) []string {
+	t.Helper()
+	var lines []string
+	for start := time.Now(); time.Since(start) < TEST_TIMEOUT; time.Sleep(time.Millisecond * 10) {
+		b, _ := ioutil.ReadFile(path)
+		if lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"); len(b) > 0 && len(lines) >= n {
+			return lines
+		}
+	}
+	t.Fatalf("%s has %d lines, want %d", path, len(lines), n)
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands need sh")
+	}
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "log")
+	stdin := filepath.Join(dir, "stdin")
+	record := `echo "$FANCONTROLLER_EVENT $FANCONTROLLER_PORT $FANCONTROLLER_SENSOR $FANCONTROLLER_TEMPERATURE $FANCONTROLLER_DIRECTION" >> ` + logPath
+	h := NewHooks(&AppConfig{
+		TempAlarmHysteresis: 3,
+		Hooks: []Hook{
+			{Event: HOOK_CONNECTED, Command: []string{"sh", "-c", "cat > " + stdin + "; " + record}},
+			{Event: HOOK_TEMPERATURE, Command: []string{"sh", "-c", record}, Sensor: "a", Limit: 40},
+			{Event: HOOK_TEMPERATURE, Command: []string{"sh", "-c", record}, Sensor: "C", Limit: 40},
+			{Event: HOOK_TEMPERATURE, Command: []string{"sh", "-c", record}, Sensor: "E", Limit: 40},
+			{Event: "started", Command: []string{"sh", "-c", record}},
+			{Event: HOOK_APPLIED},
+		},
+	})
+	if len(h.hooks) != 3 {
+		t.Fatalf("%d hooks", len(h.hooks))
+	}
+
+	h.OnEvent(Connected{Port: "mem"})
+	if lines := waitLines(t, logPath, 1); lines[0] != "connected mem   " {
+		t.Fatalf("log %q", lines)
+	}
+	var event HookEvent
+	b, _ := ioutil.ReadFile(stdin)
+	if err := json.Unmarshal(b, &event); err != nil || event.Event != HOOK_CONNECTED || event.Port != "mem" || event.Time.IsZero() {
+		t.Fatalf("stdin %s err=%v", b, err)
+	}
+
+	// sensor C isn't connected
+	h.OnEvent(ConfigReceived{Config: testConfig})
+	tests := []struct {
+		temp int16
+		line string
+	}{
+		{39, ""},
+		{40, "temperature mem A 40 above"},
+		{45, ""},
+		{37, ""},
+		{36, "temperature mem A 36 below"},
+		{39, ""},
+		{41, "temperature mem A 41 above"},
+	}
+	n := 1
+	for _, test := range tests {
+		h.OnStatus(Status{Temperatures: Temperatures{SensorA: test.temp, SensorC: 100}})
+		if test.line == "" {
+			continue
+		}
+		n++
+		if lines := waitLines(t, logPath, n); lines[n-1] != test.line {
+			t.Fatalf("at %d: log %q, want %q", test.temp, lines, test.line)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+	if lines := waitLines(t, logPath, n); len(lines) != n {
+		t.Fatalf("log %q", lines)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HTTP_MAX_BODY     = 64 * 1024
+	HTTP_STOP_TIMEOUT = time.Second * 3
+)
+
+type apiError struct {
+	Error string
+}
+
+// APIServer exposes status and config of the controller over HTTP:
+// GET /status, GET /config, PUT /config, PATCH /fans/{n} and Prometheus
+// metrics on GET /metrics.
+type APIServer struct {
+	serial    *Serial
+	appConfig *AppConfig
+
+	server *http.Server
+}
+
+func NewAPIServer(serial *Serial, appConfig *AppConfig) *APIServer {
+	api := &APIServer{
+		serial:    serial,
+		appConfig: appConfig,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", api.handleStatus)
+	mux.HandleFunc("/config", api.handleConfig)
+	mux.HandleFunc("/fans/", api.handleFan)
+	mux.Handle("/metrics", newMetricsHandler(serial))
+	api.server = &http.Server{Addr: appConfig.HTTPAddr, Handler: api.authorize(mux)}
+	return api
+}
+
+// Start serves the API in background when AppConfig.HTTPAddr is set.
+func (api *APIServer) Start() {
+	if api.appConfig.HTTPAddr == "" {
+		return
+	}
+	if host, _, err := net.SplitHostPort(api.appConfig.HTTPAddr); err == nil && api.appConfig.HTTPToken == "" {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			log.Printf("HTTP API listens on non-loopback address %s without HTTPToken", api.appConfig.HTTPAddr)
+		}
+	}
+	go func() {
+		log.Printf("HTTP API is listening on %s", api.appConfig.HTTPAddr)
+		if err := api.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("err=%v", err)
+		}
+	}()
+}
+
+func (api *APIServer) Stop() {
+	if api.appConfig.HTTPAddr == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), HTTP_STOP_TIMEOUT)
+	defer cancel()
+	api.server.Shutdown(ctx)
+}
+
+func (api *APIServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.appConfig.HTTPToken != "" {
+			expected := "Bearer " + api.appConfig.HTTPToken
+			got := r.Header.Get("Authorization")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSON(w, http.StatusUnauthorized, apiError{Error: "Unauthorized"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	io.WriteString(w, ToJSON(v)+"\n")
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiError{Error: err.Error()})
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, HTTP_MAX_BODY))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func (api *APIServer) checkConnected(w http.ResponseWriter) bool {
+	if !api.serial.Connected() {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Not connected to fan controller"))
+		return false
+	}
+	return true
+}
+
+func (api *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	if api.checkConnected(w) {
+		writeJSON(w, http.StatusOK, api.serial.GetStatus())
+	}
+}
+
+func (api *APIServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !api.checkConnected(w) {
+			return
+		}
+		if config, ok := api.serial.CurrentConfig(); ok {
+			writeJSON(w, http.StatusOK, config)
+		} else {
+			writeError(w, http.StatusServiceUnavailable, ErrNoConfig)
+		}
+	case http.MethodPut:
+		var config Config
+		if err := readJSON(r, &config); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		api.update(w, r, func(current *Config) error {
+			*current = config
+			return nil
+		})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+func (api *APIServer) handleFan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	fan, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/fans/"))
+	if err != nil || fan < 1 || fan > FAN_COUNT {
+		writeError(w, http.StatusNotFound, errors.New("Unknown fan"))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, HTTP_MAX_BODY))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// fields missing in the body keep their current values
+	api.update(w, r, func(config *Config) error {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(config.FanConfig(fan)); err != nil {
+			return &httpError{code: http.StatusBadRequest, err: err}
+		}
+		return nil
+	})
+}
+
+// httpError is returned by update functions to answer with code.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// update applies the current config changed by change, see
+// Serial.UpdateConfig.
+func (api *APIServer) update(w http.ResponseWriter, r *http.Request, change func(*Config) error) {
+	if !api.checkConnected(w) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), APPLY_TIMEOUT)
+	defer cancel()
+	config, err := api.serial.UpdateConfig(ctx, func(config *Config) error {
+		if err := change(config); err != nil {
+			return err
+		}
+		if err := validateConfig(config); err != nil {
+			return &httpError{code: http.StatusUnprocessableEntity, err: err}
+		}
+		return nil
+	})
+	switch e := err.(type) {
+	case nil:
+		writeJSON(w, http.StatusOK, config)
+	case *httpError:
+		writeError(w, e.code, e.err)
+	case *ControllerError:
+		writeError(w, http.StatusBadGateway, err)
+	case *TimeoutError:
+		writeError(w, http.StatusGatewayTimeout, err)
+	default:
+		writeError(w, http.StatusServiceUnavailable, err)
+	}
+}
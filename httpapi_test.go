@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testStatus = "FCD,25,77,0,26,30,0,50,30,593,0,0,0,0,0,0,0"
+
+// scriptedController connects a Serial to a peer which sends a status and
+// answers every command with reply, empty replies aren't sent. It returns
+// once the config of testConfig was received.
+func scriptedController(t *testing.T, reply func(cmd string) string) *Serial {
+	t.Helper()
+	a, b := NewMemTransportPair()
+	if _, err := b.Write([]byte(testStatus + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		framer := NewFramer()
+		buf := make([]byte, 256)
+		for {
+			n, err := b.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, frame := range framer.Feed(buf[:n]) {
+				r := reply(string(frame))
+				if string(frame) == "FCQ" && r == "" {
+					r = "FCR," + configValuesToStr(&testConfig)
+				}
+				if r != "" {
+					b.Write([]byte(r + "\r\n"))
+				}
+			}
+		}
+	}()
+
+	ser := NewSerial(&AppConfig{})
+	if err := ser.ConnectTransport(a, "mem"); err != nil {
+		t.Fatalf("connect err=%v", err)
+	}
+	t.Cleanup(ser.StopRead)
+	for start := time.Now(); ; time.Sleep(time.Millisecond * 5) {
+		if _, ok := ser.CurrentConfig(); ok {
+			break
+		} else if time.Since(start) > TEST_TIMEOUT {
+			t.Fatal("no config")
+		}
+	}
+	return ser
+}
+
+func acceptAll(cmd string) string {
+	if strings.HasPrefix(cmd, "FCS,") {
+		return "FCA"
+	}
+	return ""
+}
+
+func TestAPIServer(t *testing.T) {
+	invalid := strings.Replace(ToJSON(testConfig), `"MinimumPower":30`, `"MinimumPower":120`, 1)
+	tests := []struct {
+		name   string
+		reply  func(string) string
+		token  string
+		auth   string
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{"status", acceptAll, "", "", "GET", "/status", "", 200, `"Temperature`},
+		{"no token", acceptAll, "secret", "", "GET", "/status", "", 401, "Unauthorized"},
+		{"wrong token", acceptAll, "secret", "Bearer secrets", "GET", "/status", "", 401, "Unauthorized"},
+		{"token", acceptAll, "secret", "Bearer secret", "GET", "/config", "", 200, `"Fan2Config"`},
+		{"not connected", nil, "", "", "GET", "/status", "", 503, "Not connected"},
+		{"put while not connected", nil, "", "", "PUT", "/config", ToJSON(testConfig), 503, "Not connected"},
+		{"put", acceptAll, "", "", "PUT", "/config", ToJSON(testConfig), 200, `"MinimumPower":30`},
+		{"put invalid json", acceptAll, "", "", "PUT", "/config", "{", 400, ""},
+		{"put unknown field", acceptAll, "", "", "PUT", "/config", `{"Fan5Config":{}}`, 400, ""},
+		{"put invalid config", acceptAll, "", "", "PUT", "/config", invalid, 422, "Invalid minimum power"},
+		{"delete", acceptAll, "", "", "DELETE", "/config", "", 405, ""},
+		{"patch fan", acceptAll, "", "", "PATCH", "/fans/2", `{"MinimumPower":40}`, 200, `"Fan2Config":{"MinimumPower":40,"SensorControlling":1`},
+		{"patch unknown fan", acceptAll, "", "", "PATCH", "/fans/5", `{"MinimumPower":40}`, 404, ""},
+		{"patch unknown field", acceptAll, "", "", "PATCH", "/fans/1", `{"Power":40}`, 400, ""},
+		{"patch out of range", acceptAll, "", "", "PATCH", "/fans/1", `{"MaximumTemperature":303}`, 422, ""},
+		{"controller error", func(cmd string) string {
+			if strings.HasPrefix(cmd, "FCS,") {
+				return "ERR:Invalid config"
+			}
+			return ""
+		}, "", "", "PUT", "/config", ToJSON(testConfig), 502, "Invalid config"},
+		{"timeout", func(string) string { return "" }, "", "", "PUT", "/config", ToJSON(testConfig), 504, ""},
+	}
+	for _, test := range tests {
+		var ser *Serial
+		if test.reply == nil {
+			ser = NewSerial(&AppConfig{})
+		} else {
+			ser = scriptedController(t, test.reply)
+		}
+		api := NewAPIServer(ser, &AppConfig{HTTPToken: test.token})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)).WithContext(ctx)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, r)
+		cancel()
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s: %d %s, want %d %s", test.name, w.Code, w.Body, test.code, test.want)
+		}
+		ser.StopRead()
+	}
+}
+
+func TestAPIServerNoConfig(t *testing.T) {
+	// the FCQ after connecting stays unanswered
+	ser := NewSerial(&AppConfig{})
+	a, b := NewMemTransportPair()
+	if _, err := b.Write([]byte(testStatus + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ser.ConnectTransport(a, "mem"); err != nil {
+		t.Fatalf("connect err=%v", err)
+	}
+	defer ser.StopRead()
+
+	api := NewAPIServer(ser, &AppConfig{})
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/config", nil),
+		httptest.NewRequest("PATCH", "/fans/1", strings.NewReader(`{"MinimumPower":40}`)),
+	} {
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, r)
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), ErrNoConfig.Error()) {
+			t.Errorf("%s %s: %d %s", r.Method, r.URL, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HWMON_ROOT          = "/sys/class/hwmon"
+	HOST_SENSOR_MAX_AGE = time.Second
+)
+
+// HostSensor is a temperature of the host read from hwmon. Chip is the
+// content of the name file of the hwmon device, the input is selected by
+// Label (tempN_label) or by Input ("temp2"), temp1 is used without both.
+type HostSensor struct {
+	Name  string
+	Chip  string
+	Label string
+	Input string
+}
+
+// HostSensors reads the configured hwmon temperatures, paths are resolved
+// again after errors as hwmon numbers can change between boots.
+type HostSensors struct {
+	root    string
+	sensors []HostSensor
+
+	lock   sync.Mutex
+	paths  []string
+	values []int
+	valid  []bool
+	read   time.Time
+}
+
+func NewHostSensors(appConfig *AppConfig) *HostSensors {
+	hs := &HostSensors{root: appConfig.HwmonRoot}
+	for _, sensor := range appConfig.HostSensors {
+		if sensor.Name == "" || sensor.Chip == "" || sensorIndex(sensor.Name) >= 0 {
+			log.Printf("Invalid host sensor %+v", sensor)
+			continue
+		}
+		hs.sensors = append(hs.sensors, sensor)
+	}
+	hs.paths = make([]string, len(hs.sensors))
+	hs.values = make([]int, len(hs.sensors))
+	hs.valid = make([]bool, len(hs.sensors))
+	return hs
+}
+
+func (hs *HostSensors) Names() []string {
+	names := make([]string, len(hs.sensors))
+	for i, sensor := range hs.sensors {
+		names[i] = sensor.Name
+	}
+	return names
+}
+
+func readSysfs(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	return strings.TrimSpace(string(b)), err
+}
+
+func (hs *HostSensors) resolve(sensor *HostSensor) (string, error) {
+	dirs, err := filepath.Glob(filepath.Join(hs.root, "*"))
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range dirs {
+		if name, err := readSysfs(filepath.Join(dir, "name")); err != nil || name != sensor.Chip {
+			continue
+		}
+		if sensor.Label == "" {
+			input := sensor.Input
+			if input == "" {
+				input = "temp1"
+			}
+			return filepath.Join(dir, input+"_input"), nil
+		}
+		labels, _ := filepath.Glob(filepath.Join(dir, "temp*_label"))
+		for _, path := range labels {
+			if label, err := readSysfs(path); err == nil && label == sensor.Label {
+				return strings.TrimSuffix(path, "_label") + "_input", nil
+			}
+		}
+	}
+	return "", fmt.Errorf("hwmon input of host sensor %s not found in %s", sensor.Name, hs.root)
+}
+
+func (hs *HostSensors) update() {
+	if time.Since(hs.read) < HOST_SENSOR_MAX_AGE {
+		return
+	}
+	hs.read = time.Now()
+	for i := range hs.sensors {
+		hs.valid[i] = false
+		if hs.paths[i] == "" {
+			path, err := hs.resolve(&hs.sensors[i])
+			if err != nil {
+				if DEBUG_INFO {
+					log.Printf("err=%v", err)
+				}
+				continue
+			}
+			hs.paths[i] = path
+		}
+		s, err := readSysfs(hs.paths[i])
+		if err != nil {
+			hs.paths[i] = ""
+			continue
+		}
+		milli, err := strconv.Atoi(s)
+		if err != nil {
+			continue
+		}
+		hs.values[i] = (milli + 500) / 1000
+		hs.valid[i] = true
+	}
+}
+
+// Temperature returns °C of the host sensor, values are read again when
+// they are older than HOST_SENSOR_MAX_AGE.
+func (hs *HostSensors) Temperature(name string) (int, bool) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.update()
+	for i, sensor := range hs.sensors {
+		if strings.EqualFold(sensor.Name, name) {
+			return hs.values[i], hs.valid[i]
+		}
+	}
+	return 0, false
+}
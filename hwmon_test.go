@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeHwmon creates a fake hwmon device dir with name and the files of
+// values.
+func writeHwmon(t *testing.T, dir, name string, values map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	values["name"] = name
+	for file, value := range values {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHostSensors(t *testing.T) {
+	root := t.TempDir()
+	writeHwmon(t, filepath.Join(root, "hwmon0"), "coretemp", map[string]string{
+		"temp1_input": "45000",
+		"temp1_label": "Package id 0",
+		"temp2_input": "50500",
+		"temp2_label": "Core 0",
+	})
+	writeHwmon(t, filepath.Join(root, "hwmon1"), "nvme", map[string]string{
+		"temp1_input": "38499",
+	})
+
+	tests := []struct {
+		sensor HostSensor
+		temp   int
+		valid  bool
+	}{
+		{HostSensor{Name: "cpu", Chip: "coretemp", Label: "Package id 0"}, 45, true},
+		{HostSensor{Name: "core0", Chip: "coretemp", Label: "Core 0"}, 51, true},
+		{HostSensor{Name: "core0input", Chip: "coretemp", Input: "temp2"}, 51, true},
+		{HostSensor{Name: "nvme", Chip: "nvme"}, 38, true},
+		{HostSensor{Name: "nolabel", Chip: "coretemp", Label: "Core 7"}, 0, false},
+		{HostSensor{Name: "nochip", Chip: "k10temp"}, 0, false},
+	}
+	appConfig := &AppConfig{HwmonRoot: root}
+	for _, test := range tests {
+		appConfig.HostSensors = append(appConfig.HostSensors, test.sensor)
+	}
+	hs := NewHostSensors(appConfig)
+	for _, test := range tests {
+		temp, valid := hs.Temperature(test.sensor.Name)
+		if temp != test.temp || valid != test.valid {
+			t.Errorf("%s = %d %v, want %d %v", test.sensor.Name, temp, valid, test.temp, test.valid)
+		}
+	}
+
+	// hwmon numbers change, the read error resolves the path again
+	if err := os.Rename(filepath.Join(root, "hwmon0"), filepath.Join(root, "hwmon2")); err != nil {
+		t.Fatal(err)
+	}
+	reread := func() (int, bool) {
+		hs.lock.Lock()
+		hs.read = time.Time{}
+		hs.lock.Unlock()
+		return hs.Temperature("cpu")
+	}
+	if _, valid := reread(); valid {
+		t.Fatal("valid after the input was removed")
+	}
+	if temp, valid := reread(); temp != 45 || !valid {
+		t.Fatalf("cpu = %d %v after renumbering", temp, valid)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	sensorNames     = []string{"A", "B", "C", "D"}
+	sensorTypeNames = []string{"not_connected", "celsius", "fahrenheit"}
+	controlNames    = []string{"sensor_a", "sensor_b", "sensor_c", "sensor_d", "sensor_a_d", "sensor_b_d", "sensor_c_d", "manual"}
+	fanTypeNames    = []string{"not_connected", "2_wire", "3_wire_x1_tacho", "3_wire_x2_tacho", "3_wire_x4_tacho", "4_wire"}
+)
+
+func typeName(names []string, v int8) string {
+	if v >= 0 && int(v) < len(names) {
+		return names[v]
+	}
+	return strconv.Itoa(int(v))
+}
+
+// MetricsCollector builds Prometheus metrics from the last Status and Config
+// of Serial on every scrape.
+type MetricsCollector struct {
+	serial *Serial
+
+	connected        *prometheus.Desc
+	temperature      *prometheus.Desc
+	output           *prometheus.Desc
+	rpm              *prometheus.Desc
+	frames           *prometheus.Desc
+	parseFailures    *prometheus.Desc
+	malformedFrames  *prometheus.Desc
+	controllerErrors *prometheus.Desc
+	reconnects       *prometheus.Desc
+}
+
+func NewMetricsCollector(serial *Serial) *MetricsCollector {
+	return &MetricsCollector{
+		serial: serial,
+		connected: prometheus.NewDesc("fancontroller_connected",
+			"Whether the fan controller is connected.", nil, nil),
+		temperature: prometheus.NewDesc("fancontroller_sensor_temperature",
+			"Sensor temperature in the unit configured for the sensor.", []string{"sensor", "sensor_type"}, nil),
+		output: prometheus.NewDesc("fancontroller_output_percent",
+			"Output power of the fan channel.", []string{"fan", "control"}, nil),
+		rpm: prometheus.NewDesc("fancontroller_fan_rpm",
+			"Fan speed.", []string{"fan", "fan_type"}, nil),
+		frames: prometheus.NewDesc("fancontroller_frames_total",
+			"Frames received from the controller.", nil, nil),
+		parseFailures: prometheus.NewDesc("fancontroller_parse_failures_total",
+			"Frames received from the controller which couldn't be parsed.", nil, nil),
+		malformedFrames: prometheus.NewDesc("fancontroller_malformed_frames_total",
+			"Status and config frames rejected for a non-numeric or out of range field.", nil, nil),
+		controllerErrors: prometheus.NewDesc("fancontroller_controller_errors_total",
+			"ERR replies of the controller.", nil, nil),
+		reconnects: prometheus.NewDesc("fancontroller_reconnects_total",
+			"Connections to the controller after the first one.", nil, nil),
+	}
+}
+
+func (mc *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mc.connected
+	ch <- mc.temperature
+	ch <- mc.output
+	ch <- mc.rpm
+	ch <- mc.frames
+	ch <- mc.parseFailures
+	ch <- mc.malformedFrames
+	ch <- mc.controllerErrors
+	ch <- mc.reconnects
+}
+
+func (mc *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := mc.serial.GetStats()
+	ch <- prometheus.MustNewConstMetric(mc.frames, prometheus.CounterValue, float64(stats.Frames))
+	ch <- prometheus.MustNewConstMetric(mc.parseFailures, prometheus.CounterValue, float64(stats.ParseFailures))
+	ch <- prometheus.MustNewConstMetric(mc.malformedFrames, prometheus.CounterValue, float64(stats.MalformedFrames))
+	ch <- prometheus.MustNewConstMetric(mc.controllerErrors, prometheus.CounterValue, float64(stats.ControllerErrors))
+	ch <- prometheus.MustNewConstMetric(mc.reconnects, prometheus.CounterValue, float64(stats.Reconnects))
+
+	if !mc.serial.Connected() {
+		ch <- prometheus.MustNewConstMetric(mc.connected, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(mc.connected, prometheus.GaugeValue, 1)
+
+	status := mc.serial.GetStatus()
+	config := mc.serial.GetConfig()
+
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		ch <- prometheus.MustNewConstMetric(mc.temperature, prometheus.GaugeValue, float64(status.Temperature(sensor)), sensorNames[sensor], typeName(sensorTypeNames, config.SensorType(sensor)))
+	}
+
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		fc := config.FanConfig(fan)
+		name := strconv.Itoa(fan)
+		rpmA, rpmB := status.FanRPM(fan)
+		ch <- prometheus.MustNewConstMetric(mc.output, prometheus.GaugeValue, float64(status.FanOutput(fan)), name, typeName(controlNames, fc.SensorControlling))
+		ch <- prometheus.MustNewConstMetric(mc.rpm, prometheus.GaugeValue, float64(rpmA), name+"A", typeName(fanTypeNames, fc.FanTypeA))
+		ch <- prometheus.MustNewConstMetric(mc.rpm, prometheus.GaugeValue, float64(rpmB), name+"B", typeName(fanTypeNames, fc.FanTypeB))
+	}
+}
+
+func newMetricsHandler(serial *Serial) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewMetricsCollector(serial))
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
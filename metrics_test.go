@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, ser *Serial) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newMetricsHandler(ser).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 {
+		t.Fatalf("scrape %d %s", w.Code, w.Body)
+	}
+	return w.Body.String()
+}
+
+func TestMetricsCollector(t *testing.T) {
+	ser := scriptedController(t, acceptAll)
+	ser.decode([]byte("FCD,x"))
+	metrics := scrape(t, ser)
+	for _, want := range []string{
+		"fancontroller_connected 1",
+		`fancontroller_sensor_temperature{sensor="A",sensor_type="celsius"} 25`,
+		`fancontroller_sensor_temperature{sensor="B",sensor_type="fahrenheit"} 77`,
+		`fancontroller_sensor_temperature{sensor="C",sensor_type="not_connected"} 0`,
+		`fancontroller_output_percent{control="sensor_a",fan="1"} 30`,
+		`fancontroller_output_percent{control="manual",fan="3"} 50`,
+		`fancontroller_output_percent{control="sensor_a_d",fan="4"} 30`,
+		`fancontroller_fan_rpm{fan="1A",fan_type="4_wire"} 593`,
+		`fancontroller_fan_rpm{fan="2A",fan_type="3_wire_x1_tacho"} 0`,
+		`fancontroller_fan_rpm{fan="1B",fan_type="not_connected"} 0`,
+		"fancontroller_parse_failures_total 1",
+		"fancontroller_malformed_frames_total 1",
+		"fancontroller_reconnects_total 0",
+	} {
+		if !strings.Contains(metrics, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	ser.StopRead()
+	metrics = scrape(t, ser)
+	if !strings.Contains(metrics, "fancontroller_connected 0\n") || strings.Contains(metrics, "fancontroller_sensor_temperature{") {
+		t.Errorf("metrics after StopRead:\n%s", metrics)
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	tests := []struct {
+		v    int8
+		name string
+	}{
+		{SENSOR_TYPE_C, "celsius"},
+		{SENSOR_TYPE_F, "fahrenheit"},
+		{3, "3"},
+		{-1, "-1"},
+	}
+	for _, test := range tests {
+		if name := typeName(sensorTypeNames, test.v); name != test.name {
+			t.Errorf("%d = %s, want %s", test.v, name, test.name)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	MQTT_TOPIC            = "fancontroller"
+	MQTT_DISCOVERY_PREFIX = "homeassistant"
+)
+
+var (
+	mqttSetTopic = regexp.MustCompile(`/fan/([1-4])/set/([A-Za-z]+)$`)
+)
+
+type mqttResult struct {
+	Fan     int
+	Field   string
+	Value   string
+	Success bool
+	Error   string `json:",omitempty"`
+}
+
+// MQTTBridge publishes status and config of the controller as retained
+// topics with Home Assistant discovery and applies FanConfig fields received
+// on <topic>/fan/<n>/set/<Field>.
+type MQTTBridge struct {
+	serial    *Serial
+	appConfig *AppConfig
+
+	client mqtt.Client
+	topic  string
+
+	lock        sync.Mutex
+	published   map[string]string
+	sensorUnits [SENSOR_COUNT]string
+}
+
+func NewMQTTBridge(serial *Serial, appConfig *AppConfig) *MQTTBridge {
+	bridge := &MQTTBridge{
+		serial:    serial,
+		appConfig: appConfig,
+		topic:     appConfig.MQTTTopic,
+		published: make(map[string]string),
+	}
+	if bridge.topic == "" {
+		bridge.topic = MQTT_TOPIC
+	}
+	return bridge
+}
+
+// clientID is AppConfig.MQTTClientID or the topic with host name and
+// process id, instances sharing a broker must not take over each other's
+// session.
+func (b *MQTTBridge) clientID() string {
+	if b.appConfig.MQTTClientID != "" {
+		return b.appConfig.MQTTClientID
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%s-%d", b.topic, host, os.Getpid())
+}
+
+// Start connects to AppConfig.MQTTBroker when it's set.
+func (b *MQTTBridge) Start() {
+	if b.appConfig.MQTTBroker == "" {
+		return
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.appConfig.MQTTBroker).
+		SetClientID(b.clientID()).
+		SetUsername(b.appConfig.MQTTUser).
+		SetPassword(b.appConfig.MQTTPassword).
+		SetWill(b.topic+"/availability", "offline", 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(b.onBrokerConnect).
+		SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			log.Printf("MQTT connection lost err=%v", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	b.client.Connect()
+}
+
+func (b *MQTTBridge) Stop() {
+	if b.client == nil {
+		return
+	}
+	b.client.Publish(b.topic+"/availability", 1, true, "offline").WaitTimeout(time.Second)
+	b.client.Disconnect(250)
+}
+
+func (b *MQTTBridge) onBrokerConnect(c mqtt.Client) {
+	log.Printf("Connected to MQTT broker %s", b.appConfig.MQTTBroker)
+
+	b.lock.Lock()
+	b.published = make(map[string]string)
+	b.sensorUnits = [SENSOR_COUNT]string{}
+	b.lock.Unlock()
+
+	c.Subscribe(b.topic+"/fan/+/set/+", 1, b.onSetMessage)
+	b.publishDiscovery()
+	if b.serial.Connected() {
+		b.publish("availability", "online")
+		if config, ok := b.serial.CurrentConfig(); ok {
+			b.OnConfig(config)
+		}
+		b.OnStatus(b.serial.GetStatus())
+	} else {
+		b.publish("availability", "offline")
+	}
+}
+
+// publish sends retained payload to <topic>/subtopic when it differs from the
+// previously sent one.
+func (b *MQTTBridge) publish(subtopic, payload string) {
+	if b.client == nil || !b.client.IsConnected() {
+		return
+	}
+	b.lock.Lock()
+	if b.published[subtopic] == payload {
+		b.lock.Unlock()
+		return
+	}
+	b.published[subtopic] = payload
+	b.lock.Unlock()
+
+	b.client.Publish(b.topic+"/"+subtopic, 0, true, payload)
+}
+
+func (b *MQTTBridge) discoveryConfig(component, objectID, name, stateTopic string, extra map[string]interface{}) {
+	device := map[string]interface{}{
+		"identifiers":  []string{b.topic},
+		"name":         "Fan Controller",
+		"manufacturer": "Geoff Graham",
+		"model":        "Intelligent Fan Controller",
+		"sw_version":   APP_VERSION,
+	}
+	payload := map[string]interface{}{
+		"name":               name,
+		"unique_id":          b.topic + "_" + objectID,
+		"state_topic":        b.topic + "/" + stateTopic,
+		"availability_topic": b.topic + "/availability",
+		"device":             device,
+	}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	topic := fmt.Sprintf("%s/%s/%s/%s/config", b.discoveryPrefix(), component, b.topic, objectID)
+	b.client.Publish(topic, 1, true, ToJSON(payload))
+}
+
+func (b *MQTTBridge) discoveryPrefix() string {
+	if b.appConfig.MQTTDiscoveryPrefix != "" {
+		return b.appConfig.MQTTDiscoveryPrefix
+	}
+	return MQTT_DISCOVERY_PREFIX
+}
+
+// publishSensorDiscovery announces the temperature sensors once their unit
+// is known from the config and again when it changes.
+func (b *MQTTBridge) publishSensorDiscovery(config Config) {
+	if b.client == nil || !b.client.IsConnected() {
+		return
+	}
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		name := sensorNames[sensor]
+		unit := config.SensorUnit(sensor)
+		b.lock.Lock()
+		changed := b.sensorUnits[sensor] != unit
+		b.sensorUnits[sensor] = unit
+		b.lock.Unlock()
+		if changed {
+			b.discoveryConfig("sensor", "temp_"+strings.ToLower(name), "Sensor "+name,
+				"sensor/"+strings.ToLower(name)+"/temperature",
+				map[string]interface{}{"device_class": "temperature", "unit_of_measurement": unit, "state_class": "measurement"})
+		}
+	}
+}
+
+func (b *MQTTBridge) publishDiscovery() {
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		n := strconv.Itoa(fan)
+		b.discoveryConfig("sensor", "output_"+n, "Output Fans "+n+"A, "+n+"B", "fan/"+n+"/output",
+			map[string]interface{}{"unit_of_measurement": "%", "state_class": "measurement", "icon": "mdi:fan"})
+		for _, ab := range []string{"a", "b"} {
+			b.discoveryConfig("sensor", "rpm_"+n+ab, "Fan "+n+strings.ToUpper(ab), "fan/"+n+ab+"/rpm",
+				map[string]interface{}{"unit_of_measurement": "RPM", "state_class": "measurement", "icon": "mdi:fan"})
+		}
+		b.discoveryConfig("number", "minimum_power_"+n, "Fans "+n+" minimum power", "fan/"+n+"/MinimumPower",
+			map[string]interface{}{"command_topic": b.topic + "/fan/" + n + "/set/MinimumPower", "min": 0, "max": 100, "unit_of_measurement": "%"})
+		b.discoveryConfig("select", "control_"+n, "Fans "+n+" control", "fan/"+n+"/SensorControlling",
+			map[string]interface{}{"command_topic": b.topic + "/fan/" + n + "/set/SensorControlling", "options": controlNames})
+	}
+}
+
+func (b *MQTTBridge) OnEvent(ev Event) {
+	switch ev := ev.(type) {
+	case Connected:
+		b.publish("availability", "online")
+	case StatusReceived:
+		b.OnStatus(ev.Status)
+	case ConfigReceived:
+		b.OnConfig(ev.Config)
+	case Disconnected, Closed:
+		b.publish("availability", "offline")
+	}
+}
+
+func (b *MQTTBridge) OnStatus(status Status) {
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		b.publish("sensor/"+strings.ToLower(sensorNames[sensor])+"/temperature", strconv.Itoa(int(status.Temperature(sensor))))
+	}
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		n := strconv.Itoa(fan)
+		rpmA, rpmB := status.FanRPM(fan)
+		b.publish("fan/"+n+"/output", strconv.Itoa(int(status.FanOutput(fan))))
+		b.publish("fan/"+n+"a/rpm", strconv.Itoa(int(rpmA)))
+		b.publish("fan/"+n+"b/rpm", strconv.Itoa(int(rpmB)))
+	}
+	b.publish("status", ToJSON(status))
+}
+
+func (b *MQTTBridge) OnConfig(config Config) {
+	b.publishSensorDiscovery(config)
+	b.publish("config", ToJSON(config))
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		fc := config.FanConfig(fan)
+		n := strconv.Itoa(fan)
+		b.publish("fan/"+n+"/config", ToJSON(fc))
+		b.publish("fan/"+n+"/MinimumPower", strconv.Itoa(int(fc.MinimumPower)))
+		b.publish("fan/"+n+"/SensorControlling", typeName(controlNames, fc.SensorControlling))
+	}
+}
+
+// fanFieldRanges are the values accepted for the FanConfig fields over MQTT.
+var fanFieldRanges = map[string][2]int{
+	"MinimumPower":       {0, 100},
+	"SensorControlling":  {SENSOR_A, MANUAL_CONTROL},
+	"MinimumTemperature": {0, MAX_SENSOR_TEMP},
+	"MaximumTemperature": {0, MAX_SENSOR_TEMP},
+	"AllowStopped":       {0, 1},
+	"FanTypeA":           {FAN_NOT_CONNECTED, FAN_4_WIRE},
+	"FanTypeB":           {FAN_NOT_CONNECTED, FAN_4_WIRE},
+}
+
+// fanFieldValue converts an MQTT payload to the value of the FanConfig
+// field, names of controls and fan types and on/off are accepted as well as
+// numbers.
+func fanFieldValue(field, payload string) (int, error) {
+	limits, ok := fanFieldRanges[field]
+	if !ok {
+		return 0, fmt.Errorf("Unknown field %s", field)
+	}
+	value := strings.TrimSpace(payload)
+	names := map[string][]string{
+		"SensorControlling": controlNames,
+		"FanTypeA":          fanTypeNames,
+		"FanTypeB":          fanTypeNames,
+	}[field]
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	if field == "AllowStopped" {
+		switch strings.ToLower(value) {
+		case "on", "true":
+			return 1, nil
+		case "off", "false":
+			return 0, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value %q for %s", payload, field)
+	}
+	if v < limits[0] || v > limits[1] {
+		return 0, fmt.Errorf("Value %d for %s is out of range %d-%d", v, field, limits[0], limits[1])
+	}
+	return v, nil
+}
+
+func setFanConfigField(fc *FanConfig, field string, v int) {
+	switch field {
+	case "MinimumPower":
+		fc.MinimumPower = int8(v)
+	case "SensorControlling":
+		fc.SensorControlling = int8(v)
+	case "MinimumTemperature":
+		fc.MinimumTemperature = int16(v)
+	case "MaximumTemperature":
+		fc.MaximumTemperature = int16(v)
+	case "AllowStopped":
+		fc.AllowStopped = v == 1
+	case "FanTypeA":
+		fc.FanTypeA = int8(v)
+	case "FanTypeB":
+		fc.FanTypeB = int8(v)
+	}
+}
+
+func (b *MQTTBridge) onSetMessage(c mqtt.Client, m mqtt.Message) {
+	sp := mqttSetTopic.FindStringSubmatch(m.Topic())
+	if sp == nil || m.Retained() {
+		return
+	}
+	fan, _ := strconv.Atoi(sp[1])
+	result := mqttResult{Fan: fan, Field: sp[2], Value: string(m.Payload())}
+	go func() {
+		err := b.setFanField(fan, result.Field, result.Value)
+		result.Success = err == nil
+		if err != nil {
+			result.Error = err.Error()
+			log.Printf("MQTT set fan %d %s=%q err=%v", fan, result.Field, result.Value, err)
+		}
+		c.Publish(b.topic+"/fan/"+sp[1]+"/result", 1, false, ToJSON(result))
+	}()
+}
+
+func (b *MQTTBridge) setFanField(fan int, field, payload string) error {
+	v, err := fanFieldValue(field, payload)
+	if err != nil {
+		return err
+	}
+	if !b.serial.Connected() {
+		return ErrNotConnected
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), APPLY_TIMEOUT)
+	defer cancel()
+	_, err = b.serial.UpdateConfig(ctx, func(config *Config) error {
+		setFanConfigField(config.FanConfig(fan), field, v)
+		return validateConfig(config)
+	})
+	return err
+}
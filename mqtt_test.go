@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFanFieldValue(t *testing.T) {
+	tests := []struct {
+		field   string
+		payload string
+		value   int
+		valid   bool
+	}{
+		{"MinimumPower", "40", 40, true},
+		{"MinimumPower", " 100\n", 100, true},
+		{"MinimumPower", "101", 0, false},
+		{"MinimumPower", "-1", 0, false},
+		{"MinimumPower", "40.5", 0, false},
+		{"MinimumPower", "", 0, false},
+		{"MaximumTemperature", "302", 302, true},
+		{"MaximumTemperature", "303", 0, false},
+		{"SensorControlling", "manual", MANUAL_CONTROL, true},
+		{"SensorControlling", "Sensor_B", SENSOR_B, true},
+		{"SensorControlling", "7", MANUAL_CONTROL, true},
+		{"SensorControlling", "8", 0, false},
+		{"SensorControlling", "sensor_e", 0, false},
+		{"FanTypeB", "4_wire", FAN_4_WIRE, true},
+		{"FanTypeA", "not_connected", FAN_NOT_CONNECTED, true},
+		{"AllowStopped", "ON", 1, true},
+		{"AllowStopped", "false", 0, true},
+		{"AllowStopped", "1", 1, true},
+		{"AllowStopped", "yes", 0, false},
+		{"MinimumPower", "on", 0, false},
+		{"Power", "40", 0, false},
+	}
+	for _, test := range tests {
+		v, err := fanFieldValue(test.field, test.payload)
+		if (err == nil) != test.valid || v != test.value {
+			t.Errorf("%s=%q: %d err=%v, want %d", test.field, test.payload, v, err, test.value)
+		}
+	}
+}
+
+func TestMQTTSetFanField(t *testing.T) {
+	b := NewMQTTBridge(NewSerial(&AppConfig{}), &AppConfig{})
+	if err := b.setFanField(1, "MinimumPower", "40"); err != ErrNotConnected {
+		t.Fatalf("err=%v while not connected", err)
+	}
+
+	var sent []string
+	ser := scriptedController(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "FCS,") {
+			sent = append(sent, cmd)
+		}
+		return acceptAll(cmd)
+	})
+	b = NewMQTTBridge(ser, &AppConfig{})
+	if err := b.setFanField(2, "AllowStopped", "off"); err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	// fan 1 runs from 30 to 50 °C
+	if err := b.setFanField(1, "MinimumTemperature", "60"); err == nil {
+		t.Fatal("applied a minimum above the maximum")
+	}
+
+	want := testConfig
+	want.Fan2Config.AllowStopped = false
+	if len(sent) != 1 || sent[0] != configToStr(&want) {
+		t.Fatalf("sent %q", sent)
+	}
+	if config := ser.GetConfig(); config != want {
+		t.Fatalf("config %+v", config.Fan2Config)
+	}
+}
+
+func TestMQTTClientID(t *testing.T) {
+	if id := NewMQTTBridge(nil, &AppConfig{MQTTClientID: "desk"}).clientID(); id != "desk" {
+		t.Errorf("configured id %s", id)
+	}
+	id := NewMQTTBridge(nil, &AppConfig{MQTTTopic: "fans"}).clientID()
+	if !strings.HasPrefix(id, "fans-") || id == "fans" {
+		t.Errorf("id %s", id)
+	}
+}
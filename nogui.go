@@ -0,0 +1,12 @@
+//go:build nogui
+// +build nogui
+
+package main
+
+import (
+	"log"
+)
+
+func runGUI(appConfig *AppConfig) {
+	log.Fatalf("Built without GUI, use \"fancontroller daemon\"")
+}
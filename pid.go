@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	FAN_PID_INTERVAL      = 1
+	FAN_PID_SAMPLES       = 600
+	FAN_PID_FALLBACK_BAND = 10
+)
+
+// FanPID keeps Sensor at Target by adjusting the power of Fan between
+// MinPower and MaxPower, the error is measured minus target so a hot sensor
+// gives more power.
+type FanPID struct {
+	Fan      int
+	Sensor   string
+	Target   float64
+	Kp       float64
+	Ki       float64
+	Kd       float64
+	MinPower int
+	MaxPower int
+}
+
+// PIDSample is one update of a PID loop, shown in the tuning view.
+type PIDSample struct {
+	Time     time.Time
+	Target   float64
+	Measured float64
+	Output   float64
+}
+
+type pidState struct {
+	started  bool
+	integral float64
+	prev     float64
+	last     time.Time
+	output   float64
+	samples  []PIDSample
+}
+
+// validate checks pid, Target is in the unit of Sensor and limited to
+// maxTemp like the temperatures of the GUI.
+func (pid *FanPID) validate(hostNames []string, maxTemp int) error {
+	if pid.Fan < 1 || pid.Fan > FAN_COUNT {
+		return fmt.Errorf("Invalid fan %d of PID", pid.Fan)
+	}
+	if !validSensor(pid.Sensor, hostNames) {
+		return fmt.Errorf("Invalid sensor %q of PID %d", pid.Sensor, pid.Fan)
+	}
+	if pid.MaxPower == 0 {
+		pid.MaxPower = 100
+	}
+	if pid.MinPower < 0 || pid.MaxPower > 100 || pid.MinPower > pid.MaxPower {
+		return fmt.Errorf("Invalid power range %d-%d of PID %d", pid.MinPower, pid.MaxPower, pid.Fan)
+	}
+	if pid.Target <= 0 || pid.Target > float64(maxTemp) {
+		return fmt.Errorf("Invalid target %.1f of PID %d", pid.Target, pid.Fan)
+	}
+	return nil
+}
+
+// update calculates the output for measured, the first update starts from
+// power so taking over a fan doesn't change its speed. The integral is only
+// taken over when the output isn't clamped or it moves the output back into
+// the range.
+func (pid *FanPID) update(st *pidState, measured, power float64, now time.Time) {
+	e := measured - pid.Target
+	if !st.started {
+		st.started = true
+		st.integral = power - pid.Kp*e
+		st.prev = measured
+		st.last = now
+	}
+	dt := now.Sub(st.last).Seconds()
+	st.last = now
+
+	d := 0.0
+	if dt > 0 {
+		// on the measurement so changing Target doesn't kick the output
+		d = pid.Kd * (measured - st.prev) / dt
+	}
+	st.prev = measured
+
+	integral := st.integral + pid.Ki*e*dt
+	out := pid.Kp*e + integral + d
+	min, max := float64(pid.MinPower), float64(pid.MaxPower)
+	switch {
+	case out > max:
+		out = max
+		if e < 0 {
+			st.integral = integral
+		}
+	case out < min:
+		out = min
+		if e > 0 {
+			st.integral = integral
+		}
+	default:
+		st.integral = integral
+	}
+	st.output = out
+
+	st.samples = append(st.samples, PIDSample{Time: now, Target: pid.Target, Measured: measured, Output: out})
+	if len(st.samples) > FAN_PID_SAMPLES {
+		st.samples = st.samples[len(st.samples)-FAN_PID_SAMPLES:]
+	}
+}
@@ -0,0 +1,118 @@
+//go:build !nogui
+// +build !nogui
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/andlabs/ui"
+)
+
+var (
+	pidTargetColor   = [3]float64{0.4, 0.4, 0.4}
+	pidMeasuredColor = [3]float64{0.85, 0.15, 0.15}
+	pidOutputColor   = [3]float64{0.15, 0.3, 0.85}
+)
+
+// PIDChart draws setpoint and measured temperature on the temperature scale
+// and the output on the 0-100 % scale.
+type PIDChart struct {
+	chartNoInput
+	maxTemp float64
+	samples []PIDSample
+}
+
+func (c *PIDChart) Draw(a *ui.Area, p *ui.AreaDrawParams) {
+	w, h := p.AreaWidth, p.AreaHeight
+	drawChartGrid(p)
+	if len(c.samples) < 2 {
+		return
+	}
+
+	from, to := c.samples[0].Time, c.samples[len(c.samples)-1].Time
+	span := float64(to.Sub(from))
+	if span == 0 {
+		return
+	}
+	line := func(value func(s *PIDSample) float64, max float64, color [3]float64, dashed bool) {
+		path := ui.DrawNewPath(ui.DrawFillModeWinding)
+		for i := range c.samples {
+			v := value(&c.samples[i]) / max
+			if v > 1 {
+				v = 1
+			} else if v < 0 {
+				v = 0
+			}
+			x := w * float64(c.samples[i].Time.Sub(from)) / span
+			if i == 0 {
+				path.NewFigure(x, h-h*v)
+			} else {
+				path.LineTo(x, h-h*v)
+			}
+		}
+		path.End()
+		strokeChartPath(p, path, color, dashed)
+	}
+	line(func(s *PIDSample) float64 { return s.Target }, c.maxTemp, pidTargetColor, true)
+	line(func(s *PIDSample) float64 { return s.Measured }, c.maxTemp, pidMeasuredColor, false)
+	line(func(s *PIDSample) float64 { return s.Output }, 100, pidOutputColor, false)
+}
+
+// pidUnit returns the unit of the sensor of pid, host sensors are °C.
+func (app *AppGUI) pidUnit(pid *FanPID) string {
+	if sensor := sensorIndex(pid.Sensor); sensor >= 0 {
+		config := app.serial.GetConfig()
+		return config.SensorUnit(sensor)
+	}
+	return "°C"
+}
+
+// setPIDTitles shows the temperatures of the PID view in the sensor unit,
+// it's known once the config was received.
+func (app *AppGUI) setPIDTitles(fanPage *FanPage, pid *FanPID, unit string) {
+	fanPage.PIDGroup.SetTitle(fmt.Sprintf("PID: sensor %s at %.1f %s, Kp %g, Ki %g, Kd %g, power %d - %d %%",
+		pid.Sensor, pid.Target, unit, pid.Kp, pid.Ki, pid.Kd, pid.MinPower, pid.MaxPower))
+	fanPage.PIDScale.SetText(fmt.Sprintf("setpoint gray, measured red (0 - %d %s), output blue (0 - 100 %%)", app.appConfig.MaxTemp, unit))
+}
+
+func (app *AppGUI) makePIDView(fanPage *FanPage, fan int) ui.Control {
+	pid := app.curves.PID(fan)
+	group := ui.NewGroup("PID")
+	group.SetMargined(true)
+	fanPage.PIDGroup = group
+
+	grid := ui.NewGrid()
+	grid.SetPadded(true)
+	group.SetChild(grid)
+
+	fanPage.PIDLabel = ui.NewLabel("Waiting for manual control")
+	grid.Append(fanPage.PIDLabel, 0, 0, 1, 1, true, ui.AlignStart, false, ui.AlignCenter)
+	fanPage.PIDScale = ui.NewLabel("")
+	grid.Append(fanPage.PIDScale, 1, 0, 1, 1, false, ui.AlignEnd, false, ui.AlignCenter)
+	app.setPIDTitles(fanPage, pid, app.pidUnit(pid))
+	fanPage.PIDChart = &PIDChart{maxTemp: float64(app.appConfig.MaxTemp)}
+	fanPage.PIDArea = ui.NewArea(fanPage.PIDChart)
+	grid.Append(fanPage.PIDArea, 0, 1, 2, 1, true, ui.AlignFill, true, ui.AlignFill)
+	return group
+}
+
+// updatePIDViews runs on the UI thread with the status page repaint.
+func (app *AppGUI) updatePIDViews() {
+	fanPages := []*FanPage{&app.fan1Page, &app.fan2Page, &app.fan3Page, &app.fan4Page}
+	for i, fanPage := range fanPages {
+		if fanPage.PIDChart == nil {
+			continue
+		}
+		pid := app.curves.PID(i + 1)
+		unit := app.pidUnit(pid)
+		app.setPIDTitles(fanPage, pid, unit)
+		samples := app.curves.PIDSamples(i + 1)
+		fanPage.PIDChart.samples = samples
+		if len(samples) > 0 {
+			last := samples[len(samples)-1]
+			fanPage.PIDLabel.SetText(fmt.Sprintf("Setpoint %.1f %s, measured %.0f %s, output %.0f %%", last.Target, unit, last.Measured, unit, last.Output))
+		}
+		fanPage.PIDArea.QueueRedrawAll()
+	}
+}
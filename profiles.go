@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	PROFILE_EXT = ".toml"
+)
+
+// profilesDir is the directory next to the app config which keeps named
+// controller configs.
+func profilesDir() string {
+	return filepath.Join(filepath.Dir(APP_CONFIG), "profiles")
+}
+
+func profilePath(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, `/\:`) || strings.HasPrefix(name, ".") {
+		return "", errors.New("Invalid profile name \"" + name + "\"")
+	}
+	return filepath.Join(profilesDir(), name+PROFILE_EXT), nil
+}
+
+func listProfiles() []string {
+	names := make([]string, 0)
+	files, err := ioutil.ReadDir(profilesDir())
+	if err != nil {
+		return names
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), PROFILE_EXT) {
+			names = append(names, strings.TrimSuffix(f.Name(), PROFILE_EXT))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func loadProfile(name string) (Config, error) {
+	var config Config
+	path, err := profilePath(name)
+	if err != nil {
+		return config, err
+	}
+	if _, err = toml.DecodeFile(path, &config); err != nil {
+		return config, err
+	}
+	return config, validateConfig(&config)
+}
+
+func saveProfile(name string, config *Config) error {
+	path, err := profilePath(name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(profilesDir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return toml.NewEncoder(f).Encode(config)
+}
+
+// matchProfile returns the name of the first profile equal to config or an
+// empty string.
+func matchProfile(config Config) string {
+	for _, name := range listProfiles() {
+		if profile, err := loadProfile(name); err == nil && profile == config {
+			return name
+		}
+	}
+	return ""
+}
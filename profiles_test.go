@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inTempDir runs the test in a new directory, so the files next to
+// APP_CONFIG end up there.
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestProfilePath(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"silent", true},
+		{" gaming ", true},
+		{"night mode", true},
+		{"", false},
+		{"  ", false},
+		{".hidden", false},
+		{"../silent", false},
+		{`a\b`, false},
+		{"c:silent", false},
+	}
+	for _, test := range tests {
+		path, err := profilePath(test.name)
+		if (err == nil) != test.valid {
+			t.Errorf("%q err=%v", test.name, err)
+		} else if err == nil && filepath.Base(path) != strings.TrimSpace(test.name)+PROFILE_EXT {
+			t.Errorf("%q path %s", test.name, path)
+		}
+	}
+}
+
+func TestMatchProfile(t *testing.T) {
+	inTempDir(t)
+	if name := matchProfile(testConfig); name != "" {
+		t.Fatalf("matched %q without profiles", name)
+	}
+
+	loud := testConfig
+	loud.Fan1Config.MinimumPower = 100
+	for name, config := range map[string]*Config{"silent": &testConfig, "loud": &loud, "silent2": &testConfig} {
+		if err := saveProfile(name, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// broken profiles are skipped
+	if err := ioutil.WriteFile(filepath.Join(profilesDir(), "broken"+PROFILE_EXT), []byte("Fan1Config = 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(listProfiles(), ","); names != "broken,loud,silent,silent2" {
+		t.Fatalf("profiles %s", names)
+	}
+
+	other := testConfig
+	other.Fan4Config.MinimumPower = 0
+	tests := []struct {
+		config Config
+		name   string
+	}{
+		{testConfig, "silent"},
+		{loud, "loud"},
+		{other, ""},
+	}
+	for _, test := range tests {
+		if name := matchProfile(test.config); name != test.name {
+			t.Errorf("matched %q, want %q", name, test.name)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	RECONNECT_MIN_DELAY = time.Second
+	RECONNECT_MAX_DELAY = time.Minute
+)
+
+// Reconnector connects Serial to the last port again after the link was
+// lost, the delay between attempts doubles up to RECONNECT_MAX_DELAY. It
+// publishes Reconnecting before each attempt and Reconnected at the end, the
+// config is queried by Serial on every connect.
+type Reconnector struct {
+	serial *Serial
+
+	lock     sync.Mutex
+	portName string
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewReconnector(serial *Serial) *Reconnector {
+	return &Reconnector{serial: serial}
+}
+
+// Connect connects to portName and remembers it for reconnecting, "auto"
+// scans again on every attempt.
+func (r *Reconnector) Connect(portName string) error {
+	r.Stop()
+	port, err := resolvePort(portName)
+	if err == nil {
+		err = r.serial.ConnectToController(port)
+	}
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	r.portName = portName
+	r.lock.Unlock()
+	return nil
+}
+
+// Stop cancels reconnecting and forgets the port, it waits for a running
+// attempt so the caller can close the port afterwards.
+func (r *Reconnector) Stop() {
+	r.lock.Lock()
+	stop, done := r.stop, r.done
+	r.stop = nil
+	r.portName = ""
+	r.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (r *Reconnector) run(portName string, err error, stop, done chan struct{}) {
+	defer close(done)
+	r.serial.StopRead()
+
+	for attempt := 1; ; attempt++ {
+		delay := reconnectDelay(attempt)
+		r.serial.Publish(Reconnecting{Attempt: attempt, Delay: delay, Err: err})
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+
+		var port string
+		port, err = resolvePort(portName)
+		if err == nil {
+			err = r.serial.ConnectToController(port)
+		}
+		if err == nil {
+			log.Printf("Reconnected to %s after %d attempts", port, attempt)
+			r.lock.Lock()
+			if r.stop == stop {
+				r.stop = nil
+			}
+			r.lock.Unlock()
+			r.serial.Publish(Reconnected{Port: port})
+			return
+		}
+		if DEBUG_INFO {
+			log.Printf("port=%s err=%v", portName, err)
+		}
+	}
+}
+
+// reconnectDelay is the wait before attempt, it doubles from
+// RECONNECT_MIN_DELAY up to RECONNECT_MAX_DELAY.
+func reconnectDelay(attempt int) time.Duration {
+	delay := RECONNECT_MIN_DELAY
+	for i := 1; i < attempt && delay < RECONNECT_MAX_DELAY; i++ {
+		delay *= 2
+	}
+	if delay > RECONNECT_MAX_DELAY {
+		delay = RECONNECT_MAX_DELAY
+	}
+	return delay
+}
+
+func (r *Reconnector) OnEvent(ev Event) {
+	lost, ok := ev.(Disconnected)
+	if !ok {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.portName == "" || r.stop != nil {
+		return
+	}
+	log.Printf("Connection lost err=%v, reconnecting to %s", lost.Err, r.portName)
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.run(r.portName, lost.Err, r.stop, r.done)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, time.Second},
+		{2, time.Second * 2},
+		{3, time.Second * 4},
+		{6, time.Second * 32},
+		{7, time.Minute},
+		{100, time.Minute},
+	}
+	for _, test := range tests {
+		if delay := reconnectDelay(test.attempt); delay != test.delay {
+			t.Errorf("attempt %d delay %v, want %v", test.attempt, delay, test.delay)
+		}
+	}
+}
+
+func TestReconnector(t *testing.T) {
+	port := tcpEmulator(t)
+	ser := NewSerial(&AppConfig{})
+	defer ser.StopRead()
+	r := NewReconnector(ser)
+	events := make(chan Event, 16)
+	ser.Subscribe(r.OnEvent)
+	ser.Subscribe(func(ev Event) {
+		switch ev.(type) {
+		case Reconnecting, Reconnected:
+			events <- ev
+		}
+	})
+	if err := r.Connect(port); err != nil {
+		t.Fatalf("connect err=%v", err)
+	}
+
+	lost := errors.New("link lost")
+	ser.Drop(lost)
+	for _, want := range []Event{Reconnecting{Attempt: 1, Delay: RECONNECT_MIN_DELAY, Err: lost}, Reconnected{Port: port}} {
+		select {
+		case ev := <-events:
+			if ev != want {
+				t.Fatalf("%+v, want %+v", ev, want)
+			}
+		case <-time.After(TEST_TIMEOUT):
+			t.Fatalf("no %T", want)
+		}
+	}
+	if !ser.Connected() {
+		t.Fatal("not connected after Reconnected")
+	}
+
+	noEvent := func(after string) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			t.Fatalf("%+v after %s", ev, after)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+	// closing on purpose isn't a lost link
+	ser.StopRead()
+	noEvent("StopRead")
+
+	// a stopped reconnector leaves the lost link alone
+	if err := r.Connect(port); err != nil {
+		t.Fatalf("connect err=%v", err)
+	}
+	r.Stop()
+	ser.Drop(lost)
+	noEvent("Stop")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PORT_AUTO           = "auto"
+	SCAN_CONFIG_TIMEOUT = time.Second * 2
+)
+
+var scanPatterns = []string{
+	"/dev/serial/by-id/*",
+	"/dev/ttyUSB*",
+	"/dev/ttyACM*",
+	"/dev/tty.usbserial*",
+	"/dev/tty.usbmodem*",
+}
+
+var ErrNoController = errors.New("No fan controller found")
+
+// ScanResult is a port where a controller sent a status frame, Config is nil
+// when it didn't answer FCQ in time.
+type ScanResult struct {
+	Port   string
+	Status Status
+	Config *Config
+}
+
+func (r *ScanResult) String() string {
+	if r.Config == nil {
+		return r.Port + ": no config reply"
+	}
+	return r.Port + ": " + configSummary(r.Config)
+}
+
+// configSummary describes the connected sensors and fans of config in one
+// line.
+func configSummary(config *Config) string {
+	units := []string{"", "°C", "°F"}
+	sensors := make([]string, 0)
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		if t := config.SensorType(sensor); t != SENSOR_NOT_CONNECTED {
+			sensors = append(sensors, fmt.Sprintf("%c %s", 'A'+sensor, units[t]))
+		}
+	}
+	parts := []string{"sensors " + strings.Join(sensors, ", ")}
+	if len(sensors) == 0 {
+		parts[0] = "no sensors"
+	}
+
+	controls := []string{"sensor A", "sensor B", "sensor C", "sensor D", "sensor A - D", "sensor B - D", "sensor C - D"}
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		fc := config.FanConfig(fan)
+		if fc.FanTypeA == FAN_NOT_CONNECTED && fc.FanTypeB == FAN_NOT_CONNECTED {
+			continue
+		}
+		if fc.SensorControlling == MANUAL_CONTROL {
+			parts = append(parts, fmt.Sprintf("fan %d manual %d %%", fan, fc.MinimumPower))
+		} else if int(fc.SensorControlling) < len(controls) {
+			parts = append(parts, fmt.Sprintf("fan %d %s %d-%d %s from %d %%", fan, controls[fc.SensorControlling],
+				fc.MinimumTemperature, fc.MaximumTemperature, config.ControlUnit(fc.SensorControlling), fc.MinimumPower))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// candidatePorts lists serial devices which may be a controller, a by-id
+// link hides the device it points to.
+func candidatePorts() []string {
+	if runtime.GOOS == "windows" {
+		ports := make([]string, 0)
+		for i := 1; i <= 32; i++ {
+			ports = append(ports, fmt.Sprintf("COM%d", i))
+		}
+		return ports
+	}
+
+	ports := make([]string, 0)
+	linked := make(map[string]bool)
+	for _, pattern := range scanPatterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil || linked[target] {
+				continue
+			}
+			linked[target] = true
+			ports = append(ports, path)
+		}
+	}
+	return ports
+}
+
+func probePort(portName string) (ScanResult, error) {
+	result := ScanResult{Port: portName}
+	client := newCLIClient(&AppConfig{})
+	if err := client.serial.ConnectToController(portName); err != nil {
+		return result, err
+	}
+	defer client.serial.StopRead()
+
+	result.Status = client.serial.GetStatus()
+	ctx, cancel := context.WithTimeout(context.Background(), SCAN_CONFIG_TIMEOUT)
+	defer cancel()
+	if config, err := client.serial.QueryConfig(ctx); err == nil {
+		result.Config = &config
+	}
+	return result, nil
+}
+
+// ScanPorts probes all candidate ports in parallel and returns the ones
+// where a controller answered.
+func ScanPorts() []ScanResult {
+	ports := candidatePorts()
+	results := make([]ScanResult, 0)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, port := range ports {
+		wg.Add(1)
+		go func(port string) {
+			defer wg.Done()
+			result, err := probePort(port)
+			if err != nil {
+				if DEBUG_INFO {
+					log.Printf("port=%s err=%v", port, err)
+				}
+				return
+			}
+			lock.Lock()
+			results = append(results, result)
+			lock.Unlock()
+		}(port)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Port < results[j].Port
+	})
+	return results
+}
+
+// resolvePort returns portName or, for "auto", the first port where a
+// controller was found.
+func resolvePort(portName string) (string, error) {
+	if portName != PORT_AUTO {
+		return portName, nil
+	}
+	results := ScanPorts()
+	if len(results) == 0 {
+		return "", ErrNoController
+	}
+	if len(results) > 1 {
+		log.Printf("Found %d controllers, using %s", len(results), results[0].Port)
+	}
+	return results[0].Port, nil
+}
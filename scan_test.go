@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigSummary(t *testing.T) {
+	none := Config{}
+	none.Fan1Config.FanTypeA = FAN_2_WIRE
+	none.Fan1Config.SensorControlling = SENSOR_C_D
+	none.Fan1Config.MaximumTemperature = 40
+	tests := []struct {
+		config  Config
+		summary string
+	}{
+		{testConfig, "sensors A °C, B °F, D °C, fan 1 sensor A 30-50 °C from 30 %, fan 2 sensor B 80-120 °F from 20 %, fan 3 manual 50 %"},
+		{none, "no sensors, fan 1 sensor C - D 0-40 °C from 0 %"},
+	}
+	for _, test := range tests {
+		if summary := configSummary(&test.config); summary != test.summary {
+			t.Errorf("summary %q, want %q", summary, test.summary)
+		}
+	}
+}
+
+func TestCandidatePorts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ports are COM1 - COM32")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"ttyUSB0", "ttyUSB1", "ttyACM0", "ttyS0"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(dir, "by-id"), 0755)
+	if err := os.Symlink("../ttyUSB1", filepath.Join(dir, "by-id", "usb-Arduino")); err != nil {
+		t.Fatal(err)
+	}
+	patterns := scanPatterns
+	defer func() { scanPatterns = patterns }()
+
+	scanPatterns = []string{dir + "/by-id/*", dir + "/ttyUSB*", dir + "/ttyACM*"}
+	ports := strings.Join(candidatePorts(), " ")
+	want := strings.Join([]string{dir + "/by-id/usb-Arduino", dir + "/ttyUSB0", dir + "/ttyACM0"}, " ")
+	if ports != want {
+		t.Fatalf("ports %s, want %s", ports, want)
+	}
+
+	scanPatterns = []string{dir + "/ttyXRUSB*"}
+	if port, err := resolvePort(PORT_AUTO); err != ErrNoController {
+		t.Fatalf("port %q err=%v without candidates", port, err)
+	}
+	if port, err := resolvePort("/dev/ttyUSB3"); port != "/dev/ttyUSB3" || err != nil {
+		t.Fatalf("port %q err=%v", port, err)
+	}
+}
+
+// tcpEmulator serves an emulator to every connection of a TCP listener and
+// returns its port name.
+func tcpEmulator(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		l.Close()
+		close(stop)
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				NewEmulator().Serve(conn, time.Millisecond*50, stop)
+			}()
+		}
+	}()
+	return TCP_SCHEME + l.Addr().String()
+}
+
+func TestProbePort(t *testing.T) {
+	port := tcpEmulator(t)
+	result, err := probePort(port)
+	if err != nil {
+		t.Fatalf("probe err=%v", err)
+	}
+	if result.Config == nil || !strings.HasPrefix(result.String(), port+": sensors ") {
+		t.Fatalf("result %s", result.String())
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if _, err = probePort(TCP_SCHEME + l.Addr().String()); err == nil {
+		t.Fatal("probe of a closed port succeeded")
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	SCHEDULER_INTERVAL = time.Second * 30
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ScheduleRule switches the controller to Target at Minute of the day on the
+// selected Days, e.g. "Mon-Fri 08:00 -> silent.toml".
+type ScheduleRule struct {
+	Text   string
+	Days   [7]bool
+	Minute int
+	Target string
+}
+
+func parseWeekday(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) >= 3 {
+		for i, v := range weekdays {
+			if strings.HasPrefix(s, v) {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("Invalid weekday %q", s)
+}
+
+func parseDays(s string, days *[7]bool) error {
+	if s == "*" || strings.EqualFold(s, "daily") {
+		for i := range days {
+			days[i] = true
+		}
+		return nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		from, err := parseWeekday(bounds[0])
+		if err != nil {
+			return err
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = parseWeekday(bounds[1]); err != nil {
+				return err
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseScheduleRule(s string) (ScheduleRule, error) {
+	rule := ScheduleRule{Text: s}
+	sp := strings.SplitN(s, "->", 2)
+	if len(sp) != 2 || strings.TrimSpace(sp[1]) == "" {
+		return rule, fmt.Errorf("Invalid schedule rule %q", s)
+	}
+	rule.Target = strings.TrimSpace(sp[1])
+
+	fields := strings.Fields(sp[0])
+	switch len(fields) {
+	case 1:
+		parseDays("*", &rule.Days)
+	case 2:
+		if err := parseDays(fields[0], &rule.Days); err != nil {
+			return rule, err
+		}
+	default:
+		return rule, fmt.Errorf("Invalid schedule rule %q", s)
+	}
+
+	hm := strings.Split(fields[len(fields)-1], ":")
+	if len(hm) != 2 {
+		return rule, fmt.Errorf("Invalid time in schedule rule %q", s)
+	}
+	h, errH := strconv.Atoi(hm[0])
+	m, errM := strconv.Atoi(hm[1])
+	if errH != nil || errM != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return rule, fmt.Errorf("Invalid time in schedule rule %q", s)
+	}
+	rule.Minute = h*60 + m
+	return rule, nil
+}
+
+// lastStart returns the latest time not after now when the rule fired.
+func (rule *ScheduleRule) lastStart(now time.Time) (time.Time, bool) {
+	for d := 0; d <= 7; d++ {
+		day := now.AddDate(0, 0, -d)
+		if !rule.Days[day.Weekday()] {
+			continue
+		}
+		t := time.Date(day.Year(), day.Month(), day.Day(), rule.Minute/60, rule.Minute%60, 0, 0, now.Location())
+		if !t.After(now) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// activeScheduleRule returns the rule which fired last, when several fire at
+// the same time the later one in the list wins.
+func activeScheduleRule(rules []ScheduleRule, now time.Time) *ScheduleRule {
+	var active *ScheduleRule
+	var activeStart time.Time
+	for i := range rules {
+		start, ok := rules[i].lastStart(now)
+		if ok && (active == nil || !start.Before(activeStart)) {
+			active = &rules[i]
+			activeStart = start
+		}
+	}
+	return active
+}
+
+// loadScheduleTarget loads a config by profile name ("silent" or
+// "silent.toml") or by path relative to the app config directory.
+func loadScheduleTarget(target string) (Config, error) {
+	if !strings.ContainsAny(target, `/\`) {
+		return loadProfile(strings.TrimSuffix(target, PROFILE_EXT))
+	}
+	var config Config
+	path := target
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(APP_CONFIG), path)
+	}
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return config, err
+	}
+	return config, validateConfig(&config)
+}
+
+// Scheduler applies stored configs according to the time of day rules from
+// AppConfig.Schedule.
+type Scheduler struct {
+	serial *Serial
+	rules  []ScheduleRule
+
+	lock      sync.Mutex
+	connected bool
+	pending   bool
+	active    string
+
+	trigger chan struct{}
+	stop    chan struct{}
+}
+
+func NewScheduler(serial *Serial, appConfig *AppConfig) *Scheduler {
+	sched := &Scheduler{
+		serial:  serial,
+		trigger: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	for _, s := range appConfig.Schedule {
+		rule, err := parseScheduleRule(s)
+		if err != nil {
+			log.Printf("err=%v", err)
+			continue
+		}
+		sched.rules = append(sched.rules, rule)
+	}
+	return sched
+}
+
+func (sched *Scheduler) Start() {
+	if len(sched.rules) == 0 {
+		return
+	}
+	go sched.run()
+}
+
+func (sched *Scheduler) Stop() {
+	close(sched.stop)
+}
+
+func (sched *Scheduler) kick() {
+	select {
+	case sched.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (sched *Scheduler) run() {
+	ticker := time.NewTicker(SCHEDULER_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sched.stop:
+			return
+		case <-ticker.C:
+		case <-sched.trigger:
+		}
+		sched.check(time.Now())
+	}
+}
+
+func (sched *Scheduler) check(now time.Time) {
+	rule := activeScheduleRule(sched.rules, now)
+	if rule == nil {
+		return
+	}
+
+	sched.lock.Lock()
+	skip := !sched.connected || sched.pending || sched.active == rule.Text
+	sched.lock.Unlock()
+	if skip {
+		return
+	}
+
+	config, err := loadScheduleTarget(rule.Target)
+	if err != nil {
+		log.Printf("Schedule rule %q err=%v", rule.Text, err)
+		sched.setActive(rule.Text)
+		return
+	}
+	if config == sched.serial.GetConfig() {
+		log.Printf("Schedule rule %q: %s is already active", rule.Text, rule.Target)
+		sched.setActive(rule.Text)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), APPLY_TIMEOUT)
+	defer cancel()
+	if err = sched.serial.Apply(ctx, &config); err != nil {
+		log.Printf("Schedule rule %q couldn't apply %s err=%v", rule.Text, rule.Target, err)
+		return
+	}
+	log.Printf("Schedule rule %q switched config to %s", rule.Text, rule.Target)
+	sched.setActive(rule.Text)
+}
+
+func (sched *Scheduler) setActive(text string) {
+	sched.lock.Lock()
+	sched.active = text
+	sched.lock.Unlock()
+}
+
+func (sched *Scheduler) OnEvent(ev Event) {
+	switch ev.(type) {
+	case Connected:
+		sched.lock.Lock()
+		sched.connected = true
+		sched.pending = true
+		sched.active = ""
+		sched.lock.Unlock()
+	case ConfigReceived:
+		sched.lock.Lock()
+		pending := sched.pending
+		sched.pending = false
+		sched.lock.Unlock()
+
+		// catch up once the config of the new connection is known
+		if pending {
+			sched.kick()
+		}
+	case Disconnected, Closed:
+		sched.lock.Lock()
+		sched.connected = false
+		sched.lock.Unlock()
+	}
+}
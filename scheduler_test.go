@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleRule(t *testing.T) {
+	tests := []struct {
+		text   string
+		days   string
+		minute int
+		target string
+	}{
+		{"08:00 -> silent", "SMTWTFS", 8 * 60, "silent"},
+		{"daily 23:59 -> night.toml", "SMTWTFS", 23*60 + 59, "night.toml"},
+		{"* 0:05->silent", "SMTWTFS", 5, "silent"},
+		{"Mon-Fri 08:30 -> work", "-MTWTF-", 8*60 + 30, "work"},
+		{"sat,Sunday 10:00 -> ../weekend.toml", "S-----S", 10 * 60, "../weekend.toml"},
+		{"Fri-Mon 18:00 -> gaming", "SM---FS", 18 * 60, "gaming"},
+		{"wed 12:00 -> a -> b", "---W---", 12 * 60, "a -> b"},
+		{"08:00", "", 0, ""},
+		{"08:00 -> ", "", 0, ""},
+		{"Mon Tue 08:00 -> silent", "", 0, ""},
+		{"Mo 08:00 -> silent", "", 0, ""},
+		{"Mon-Xyz 08:00 -> silent", "", 0, ""},
+		{"24:00 -> silent", "", 0, ""},
+		{"12:60 -> silent", "", 0, ""},
+		{"8 -> silent", "", 0, ""},
+		{"-> silent", "", 0, ""},
+	}
+	for _, test := range tests {
+		rule, err := parseScheduleRule(test.text)
+		if test.days == "" {
+			if err == nil {
+				t.Errorf("%q parsed", test.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q err=%v", test.text, err)
+			continue
+		}
+		days := ""
+		for i, on := range rule.Days {
+			if on {
+				days += string("SMTWTFS"[i])
+			} else {
+				days += "-"
+			}
+		}
+		if days != test.days || rule.Minute != test.minute || rule.Target != test.target {
+			t.Errorf("%q = %s %d %q, want %s %d %q", test.text, days, rule.Minute, rule.Target, test.days, test.minute, test.target)
+		}
+	}
+}
+
+func TestActiveScheduleRule(t *testing.T) {
+	var rules []ScheduleRule
+	for _, s := range []string{
+		"Mon-Fri 08:00 -> work",
+		"Mon-Fri 18:00 -> evening",
+		"Sat-Sun 10:00 -> weekend",
+		"Wed 18:00 -> midweek",
+	} {
+		rule, err := parseScheduleRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+
+	// 2024-01-01 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		now    time.Time
+		target string
+	}{
+		{at(1, 7, 59), "weekend"},
+		{at(1, 8, 0), "work"},
+		{at(1, 17, 59), "work"},
+		{at(1, 18, 0), "evening"},
+		{at(2, 3, 0), "evening"},
+		{at(3, 18, 0), "midweek"},
+		{at(4, 9, 0), "work"},
+		{at(6, 9, 59), "evening"},
+		{at(6, 10, 0), "weekend"},
+		{at(8, 0, 0), "weekend"},
+	}
+	for _, test := range tests {
+		rule := activeScheduleRule(rules, test.now)
+		if rule == nil || rule.Target != test.target {
+			t.Errorf("%s: %v, want %s", test.now.Format("Mon 15:04"), rule, test.target)
+		}
+	}
+
+	if rule := activeScheduleRule(nil, at(1, 8, 0)); rule != nil {
+		t.Errorf("no rules: %v", rule)
+	}
+}
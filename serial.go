@@ -1,198 +1,692 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"io"
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+)
 
-	"github.com/tarm/serial"
+const (
+	READ_BUFFER_SIZE   = 256
+	READ_BUFFER_COUNT  = 2
+	EVENT_QUEUE_SIZE   = 64
+	CHECK_PORT_TIMEOUT = time.Second * 3
+	APPLY_TIMEOUT      = time.Second * 10
+	REPLY_LOST_TIMEOUT = time.Second * 3
 )
 
-type Serial struct {
-	port *serial.Port
+// replies a command waits for
+const (
+	REPLY_NONE = iota
+	REPLY_APPLY
+	REPLY_CONFIG
+)
+
+var (
+	ErrNoStatus     = errors.New("Couldn't got fan controller status")
+	ErrNotConnected = errors.New("Not connected to fan controller")
+	ErrNoConfig     = errors.New("Fan controller config not received yet")
+)
+
+// ControllerError is the message of an ERR reply of the controller.
+type ControllerError struct {
+	Message string
+}
+
+func (e *ControllerError) Error() string {
+	return "Controller error: " + e.Message
+}
+
+// TimeoutError is returned when the controller didn't answer Command before
+// the deadline of the request.
+type TimeoutError struct {
+	Command string
+}
+
+func (e *TimeoutError) Error() string {
+	return "Timeout waiting for the reply to " + e.Command
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
 
-	status *Status
-	config *Config
+// SerialStats counts events of the link since the application start,
+// ParseFailures are all rejected frames and MalformedFrames the known ones
+// with a bad field.
+type SerialStats struct {
+	Frames           uint64
+	ParseFailures    uint64
+	MalformedFrames  uint64
+	ControllerErrors uint64
+	Reconnects       uint64
+}
+
+// serialCommand is written to the port by the I/O goroutine, the write error
+// is sent back on result. A command expecting a reply waits in the pending
+// list of the I/O goroutine until the reply is sent on reply. When ctx ends
+// first it stays as a tombstone consuming its late reply, it's dropped
+// REPLY_LOST_TIMEOUT after the write. config is the config sent by FCS, it's
+// stored when the FCA arrives.
+type serialCommand struct {
+	data   []byte
+	result chan error
 
-	appGUI    *AppGUI
+	ctx     context.Context
+	expect  int
+	reply   chan interface{}
+	config  *Config
+	written time.Time
+}
+
+// readChunk is a buffer filled by the reader, it goes back to the free list
+// once the framer copied it.
+type readChunk struct {
+	buf []byte
+	n   int
+	err error
+}
+
+// serialEvent is a decoded frame or the link loss, passed from the I/O
+// goroutine to the dispatch goroutine.
+type serialEvent struct {
+	frame interface{}
+	err   error
+}
+
+// serialConn is one connection, its I/O goroutine owns the port until ctx is
+// cancelled or the link is lost.
+type serialConn struct {
+	cancel context.CancelFunc
+	cmds   chan serialCommand
+	done   chan struct{}
+	err    error
+}
+
+// Serial publishes everything it gets from the controller on its event bus,
+// frames are published one after another from the dispatch goroutine of the
+// connection. Apply and QueryConfig wait for the reply to their command.
+type Serial struct {
+	events    *EventBus
 	appConfig *AppConfig
+	requests  chan struct{}
+
+	// checkTimeout is the wait for the first status when connecting
+	checkTimeout time.Duration
+
+	// updateLock serializes UpdateConfig from reading through applying
+	updateLock sync.Mutex
 
-	lockPort     sync.Mutex
-	stopReadPort bool
+	stats SerialStats
+
+	lock          sync.Mutex
+	conn          *serialConn
+	status        *Status
+	config        *Config
+	everConnected bool
+	fallback      func() *Config
 }
 
 func NewSerial(appConfig *AppConfig) *Serial {
 	return &Serial{
+		events:    NewEventBus(),
 		appConfig: appConfig,
+		requests:  make(chan struct{}, 1),
+
+		checkTimeout: CHECK_PORT_TIMEOUT,
+	}
+}
+
+// SetCheckTimeout sets how long connecting waits for the first status, it's
+// called before connecting.
+func (ser *Serial) SetCheckTimeout(timeout time.Duration) {
+	ser.checkTimeout = timeout
+}
+
+func (ser *Serial) Subscribe(handler func(Event)) {
+	ser.events.Subscribe(handler)
+}
+
+// Publish passes ev to the subscribers, monitors use it for their own events
+// about the link.
+func (ser *Serial) Publish(ev Event) {
+	ser.events.Publish(ev)
+}
+
+func (ser *Serial) GetStats() SerialStats {
+	return SerialStats{
+		Frames:           atomic.LoadUint64(&ser.stats.Frames),
+		ParseFailures:    atomic.LoadUint64(&ser.stats.ParseFailures),
+		MalformedFrames:  atomic.LoadUint64(&ser.stats.MalformedFrames),
+		ControllerErrors: atomic.LoadUint64(&ser.stats.ControllerErrors),
+		Reconnects:       atomic.LoadUint64(&ser.stats.Reconnects),
 	}
 }
 
+func (ser *Serial) Connected() bool {
+	ser.lock.Lock()
+	defer ser.lock.Unlock()
+	return ser.conn != nil
+}
+
 func (ser *Serial) GetConfig() Config {
+	ser.lock.Lock()
+	defer ser.lock.Unlock()
 	if ser.config != nil {
 		return *ser.config
 	}
 	return Config{}
 }
 
+// CurrentConfig returns the last config of the controller, ok is false until
+// the first FCR arrived.
+func (ser *Serial) CurrentConfig() (config Config, ok bool) {
+	ser.lock.Lock()
+	defer ser.lock.Unlock()
+	if ser.config != nil {
+		return *ser.config, true
+	}
+	return Config{}, false
+}
+
 func (ser *Serial) GetStatus() Status {
+	ser.lock.Lock()
+	defer ser.lock.Unlock()
 	if ser.status != nil {
 		return *ser.status
 	}
 	return Status{}
 }
 
+// SetFallback registers f to be asked for a config when a connection ends,
+// by StopRead or link loss. The config is written before the port is closed
+// without waiting for the reply.
+func (ser *Serial) SetFallback(f func() *Config) {
+	ser.lock.Lock()
+	defer ser.lock.Unlock()
+	ser.fallback = f
+}
+
+// StopRead closes the connection and waits until the I/O goroutine released
+// the port, Closed is published instead of Disconnected.
 func (ser *Serial) StopRead() {
-	ser.stopReadPort = true
+	if ser.stop() {
+		ser.Publish(Closed{})
+	}
+}
 
+// stop closes the connection, it returns false when there was none.
+func (ser *Serial) stop() bool {
+	ser.lock.Lock()
+	conn := ser.conn
+	ser.conn = nil
 	ser.config = nil
 	ser.status = nil
+	ser.lock.Unlock()
 
-	ser.lockPort.Lock()
-	if ser.port != nil {
-		ser.port.Close()
+	if conn == nil {
+		return false
+	}
+	conn.cancel()
+	<-conn.done
+	return true
+}
+
+// Drop closes the port and publishes Disconnected as if the link was lost.
+func (ser *Serial) Drop(err error) {
+	ser.stop()
+	ser.Publish(Disconnected{Err: err})
+}
+
+// send passes c to the I/O goroutine and waits for the write.
+func (ser *Serial) send(ctx context.Context, c serialCommand) (*serialConn, error) {
+	ser.lock.Lock()
+	conn := ser.conn
+	ser.lock.Unlock()
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	select {
+	case conn.cmds <- c:
+	case <-conn.done:
+		return nil, ErrNotConnected
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case err := <-c.result:
+		return conn, err
+	case <-conn.done:
+		return nil, ErrNotConnected
 	}
-	ser.port = nil
-	ser.lockPort.Unlock()
 }
 
-func (ser *Serial) ApplyConfig(config *Config) bool {
-	configStr := configToStr(config) + "\r\n"
+func requestError(ctx context.Context, name string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Command: name}
+	}
+	return ctx.Err()
+}
+
+// request writes cmd and waits for the reply, requests are serialized so the
+// controller has only one to answer at a time. A request giving up keeps
+// the turn until the late reply arrived or REPLY_LOST_TIMEOUT passed, so a
+// lost reply doesn't shift the replies of the following requests.
+func (ser *Serial) request(ctx context.Context, name, cmd string, expect int, config *Config) (interface{}, error) {
+	select {
+	case ser.requests <- struct{}{}:
+	case <-ctx.Done():
+		return nil, requestError(ctx, name)
+	}
+	release := true
+	defer func() {
+		if release {
+			<-ser.requests
+		}
+	}()
+
 	if DEBUG_INFO {
-		log.Printf("configStr=%s", configStr)
+		log.Printf("cmd=%s", cmd)
+	}
+	c := serialCommand{
+		data:   []byte(cmd),
+		result: make(chan error, 1),
+		ctx:    ctx,
+		expect: expect,
+		reply:  make(chan interface{}, 1),
+		config: config,
+	}
+	conn, err := ser.send(ctx, c)
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return nil, requestError(ctx, name)
+	} else if err != nil {
+		return nil, err
+	}
+	select {
+	case v := <-c.reply:
+		return v, nil
+	case <-conn.done:
+		return nil, conn.err
+	case <-ctx.Done():
+		release = false
+		go func() {
+			select {
+			case <-c.reply:
+			case <-conn.done:
+			case <-time.After(REPLY_LOST_TIMEOUT):
+			}
+			<-ser.requests
+		}()
+		return nil, requestError(ctx, name)
 	}
-	ser.lockPort.Lock()
-	_, err := ser.port.Write([]byte(configStr))
-	ser.lockPort.Unlock()
+}
+
+// Apply sends config and waits for the FCA or ERR reply, an ERR reply is
+// returned as ControllerError and a missed deadline as TimeoutError. The
+// config is stored and published with ConfigReceived once the FCA arrived.
+func (ser *Serial) Apply(ctx context.Context, config *Config) error {
+	sent := *config
+	v, err := ser.request(ctx, "FCS", configToStr(config)+"\r\n", REPLY_APPLY, &sent)
 	if err != nil {
-		if DEBUG_INFO {
-			log.Printf("err=%v", err)
+		return err
+	}
+	if msg, ok := v.(ErrorMessage); ok {
+		return &ControllerError{Message: msg.Message}
+	}
+	return nil
+}
+
+// UpdateConfig changes the current config with update and applies it.
+// Updates are serialized from reading through applying so concurrent
+// updates don't overwrite each other, ErrNoConfig is returned before the
+// config is known.
+func (ser *Serial) UpdateConfig(ctx context.Context, update func(*Config) error) (Config, error) {
+	ser.updateLock.Lock()
+	defer ser.updateLock.Unlock()
+
+	config, ok := ser.CurrentConfig()
+	if !ok {
+		return config, ErrNoConfig
+	}
+	if err := update(&config); err != nil {
+		return config, err
+	}
+	if err := ser.Apply(ctx, &config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// QueryConfig sends FCQ and waits for the config.
+func (ser *Serial) QueryConfig(ctx context.Context) (Config, error) {
+	v, err := ser.request(ctx, "FCQ", "FCQ\r\n", REPLY_CONFIG, nil)
+	if err != nil {
+		return Config{}, err
+	}
+	if msg, ok := v.(ErrorMessage); ok {
+		return Config{}, &ControllerError{Message: msg.Message}
+	}
+	return *v.(*Config), nil
+}
+
+// dropLost removes the tombstones whose reply didn't come within
+// REPLY_LOST_TIMEOUT.
+func dropLost(pending []serialCommand, now time.Time) []serialCommand {
+	rest := pending[:0]
+	for _, c := range pending {
+		if c.ctx.Err() == nil || now.Sub(c.written) < REPLY_LOST_TIMEOUT {
+			rest = append(rest, c)
 		}
-		ser.appGUI.ShowError(err, true)
-		return false
 	}
-	return true
+	return rest
 }
 
-func (ser *Serial) readPort() {
-	ser.stopReadPort = false
+// matchReply removes the oldest pending command waiting for v and returns
+// it, nil when v isn't a reply or nobody waits for it. The controller
+// answers in order, so a tombstone takes its late reply and the next
+// command gets its own. An ERR can be the reply to any command.
+func matchReply(pending []serialCommand, v interface{}) ([]serialCommand, *serialCommand) {
+	expect, anyCommand := REPLY_NONE, false
+	switch frame := v.(type) {
+	case *Config:
+		expect = REPLY_CONFIG
+	case SuccessApply:
+		expect = REPLY_APPLY
+	case ErrorMessage:
+		anyCommand = len(strings.Trim(frame.Message, " ")) > 0
+	}
+	if expect == REPLY_NONE && !anyCommand {
+		return pending, nil
+	}
+
+	for i, c := range pending {
+		if c.expect == expect || anyCommand {
+			return append(pending[:i], pending[i+1:]...), &c
+		}
+	}
+	return pending, nil
+}
+
+// readPort reads into the buffers of free and hands them to the I/O
+// goroutine, it returns after a read error or when stop is closed.
+func readPort(port Transport, free chan []byte, chunks chan<- readChunk, stop <-chan struct{}) {
 	for {
-		if ser.stopReadPort {
+		var buf []byte
+		select {
+		case buf = <-free:
+		case <-stop:
 			return
 		}
+		n, err := port.Read(buf)
+		if err == io.EOF {
+			err = nil
+		}
+		if n == 0 && err == nil {
+			free <- buf
+			continue
+		}
+		select {
+		case chunks <- readChunk{buf: buf, n: n, err: err}:
+		case <-stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
 
-		buf := make([]byte, 256)
-		ser.lockPort.Lock()
-		n, err := ser.port.Read(buf)
-		ser.lockPort.Unlock()
-		if err != nil && err != io.EOF {
-			if DEBUG_INFO {
-				log.Printf("err=%v", err)
-			}
-			ser.appGUI.ShowError(err, true)
-			ser.appGUI.CloseMainWindow(true)
+// run is the I/O goroutine of conn and the only user of port besides the
+// reader: it writes the commands, decodes what the reader got and closes
+// the port on return.
+func (ser *Serial) run(ctx context.Context, conn *serialConn, port Transport, framer *Framer, events chan<- serialEvent) {
+	free := make(chan []byte, READ_BUFFER_COUNT)
+	for i := 0; i < READ_BUFFER_COUNT; i++ {
+		free <- make([]byte, READ_BUFFER_SIZE)
+	}
+	chunks := make(chan readChunk)
+	stop := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		readPort(port, free, chunks, stop)
+		close(readerDone)
+	}()
+
+	publish := func(ev serialEvent) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	conn.err = ErrNotConnected
+	// the link loss is published after the fallback was written, the
+	// listeners may stop providing it on the disconnect
+	var lost error
+	defer func() {
+		ser.writeFallback(port)
+		close(stop)
+		port.Close()
+		<-readerDone
+		if lost != nil {
+			publish(serialEvent{err: lost})
+		}
+		close(events)
+		close(conn.done)
+	}()
+
+	var pending []serialCommand
+	for {
+		select {
+		case <-ctx.Done():
 			return
-		} else if n > 0 {
-			if DEBUG_INFO {
-				log.Printf("buf=%q", buf[:n])
+		case c := <-conn.cmds:
+			_, err := port.Write(c.data)
+			if err == nil && c.expect != REPLY_NONE {
+				c.written = time.Now()
+				pending = append(dropLost(pending, c.written), c)
 			}
-			v := parseData(buf[:n])
-			s, ok := v.(*Status)
-			if ok {
-				ser.status = s
-				ser.appGUI.UpdateStatusPage()
+			c.result <- err
+		case chunk := <-chunks:
+			if chunk.err != nil {
+				if DEBUG_INFO {
+					log.Printf("err=%v", chunk.err)
+				}
+				ser.lock.Lock()
+				if ser.conn == conn {
+					ser.conn = nil
+				}
+				ser.lock.Unlock()
+				conn.err = chunk.err
+				lost = chunk.err
+				return
 			}
-			c, ok := v.(*Config)
-			if ok {
-				ser.config = c
-				ser.appGUI.UpdateConfigPages()
-			}
-			_, ok = v.(SuccessApply)
-			if ok {
-				ser.appGUI.UpdateActionButtons(false)
-
-				go ser.queryConfig()
-				ser.appGUI.ShowMessage("Config successfully applied")
+			if DEBUG_INFO {
+				log.Printf("buf=%q", chunk.buf[:chunk.n])
 			}
-			error, ok := v.(ErrorMessage)
-			if ok && len(strings.Trim(error.Message, " ")) > 0 {
-				ser.appGUI.UpdateActionButtons(true)
-				ser.appGUI.ShowError(errors.New(error.Message), true)
+			frames := framer.Feed(chunk.buf[:chunk.n])
+			free <- chunk.buf
+			for _, frame := range frames {
+				v := ser.decode(frame)
+				ser.store(v)
+				var c *serialCommand
+				pending, c = matchReply(dropLost(pending, time.Now()), v)
+				var applied *Config
+				if _, ok := v.(SuccessApply); ok && c != nil {
+					applied = c.config
+					ser.store(applied)
+				}
+				if c != nil {
+					c.reply <- v
+				}
+				if v != nil && !publish(serialEvent{frame: v}) {
+					return
+				}
+				if applied != nil && !publish(serialEvent{frame: applied}) {
+					return
+				}
 			}
 		}
 	}
 }
 
-func (ser *Serial) checkPort() error {
-	beginTime := time.Now()
-	for {
-		delta := time.Now().Sub(beginTime)
-		if delta > time.Second*3 {
-			break
+// writeFallback writes the config of the fallback registered by SetFallback,
+// the port may already be broken so it's a best effort.
+func (ser *Serial) writeFallback(port Transport) {
+	ser.lock.Lock()
+	fallback := ser.fallback
+	ser.lock.Unlock()
+	if fallback == nil {
+		return
+	}
+	config := fallback()
+	if config == nil {
+		return
+	}
+	if _, err := port.Write([]byte(configToStr(config) + "\r\n")); err != nil {
+		log.Printf("Fallback config err=%v", err)
+	}
+}
+
+// dispatch publishes the events of one connection, events left after
+// StopRead are dropped.
+func (ser *Serial) dispatch(ctx context.Context, events <-chan serialEvent) {
+	for ev := range events {
+		if ctx.Err() != nil {
+			continue
+		}
+		if ev.err != nil {
+			ser.Publish(Disconnected{Err: ev.err})
+			continue
+		}
+		ser.handleData(ev.frame)
+	}
+}
+
+func (ser *Serial) decode(frame []byte) interface{} {
+	atomic.AddUint64(&ser.stats.Frames, 1)
+	v, err := parseData(frame)
+	if err != nil {
+		atomic.AddUint64(&ser.stats.ParseFailures, 1)
+		if _, ok := err.(*FrameError); ok {
+			atomic.AddUint64(&ser.stats.MalformedFrames, 1)
+		}
+		if DEBUG_INFO || ser.appConfig.LogRejectedFrames {
+			log.Printf("Rejected frame=%q err=%v", frame, err)
+		}
+	}
+	return v
+}
+
+// store keeps the last status and config for GetStatus and GetConfig.
+func (ser *Serial) store(v interface{}) {
+	ser.lock.Lock()
+	defer ser.lock.Unlock()
+	switch frame := v.(type) {
+	case *Status:
+		ser.status = frame
+	case *Config:
+		ser.config = frame
+	}
+}
+
+func (ser *Serial) handleData(v interface{}) {
+	switch frame := v.(type) {
+	case *Status:
+		ser.Publish(StatusReceived{Status: *frame})
+	case *Config:
+		ser.Publish(ConfigReceived{Config: *frame})
+	case SuccessApply:
+		ser.Publish(ApplySucceeded{})
+	case ErrorMessage:
+		if len(strings.Trim(frame.Message, " ")) > 0 {
+			atomic.AddUint64(&ser.stats.ControllerErrors, 1)
+			ser.Publish(ApplyFailed{Message: frame.Message})
 		}
+	}
+}
 
-		buf := make([]byte, 256)
-		ser.lockPort.Lock()
-		n, err := ser.port.Read(buf)
-		ser.lockPort.Unlock()
+// checkPort waits for a status frame before the I/O goroutine is started.
+func (ser *Serial) checkPort(port Transport, framer *Framer) (*Status, error) {
+	beginTime := time.Now()
+	buf := make([]byte, READ_BUFFER_SIZE)
+	for time.Since(beginTime) <= ser.checkTimeout {
+		n, err := port.Read(buf)
 		if err != nil && err != io.EOF {
-			return err
+			return nil, err
 		} else if n > 0 {
 			if DEBUG_INFO {
 				log.Printf("buf=%q", buf[:n])
 			}
-			v := parseData(buf[:n])
-			_, ok := v.(*Status)
-			if ok {
-				return nil
+			for _, frame := range framer.Feed(buf[:n]) {
+				s, ok := ser.decode(frame).(*Status)
+				if ok {
+					return s, nil
+				}
 			}
 		}
 	}
-	return errors.New("Couldn't got fan controller status")
+	return nil, ErrNoStatus
 }
 
+// queryConfig asks for the config after connecting, a failed request is
+// published as LinkError.
 func (ser *Serial) queryConfig() bool {
-	cmd := "FCQ\r\n"
-	if DEBUG_INFO {
-		log.Printf("queryCmd=%s", cmd)
-	}
-	ser.lockPort.Lock()
-	_, err := ser.port.Write([]byte(cmd))
-	ser.lockPort.Unlock()
-	if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), APPLY_TIMEOUT)
+	defer cancel()
+	if _, err := ser.QueryConfig(ctx); err != nil {
 		log.Printf("err=%v", err)
-
-		ser.appGUI.ShowError(err, true)
+		ser.Publish(LinkError{Err: err})
 		return false
 	}
 	return true
 }
 
-func (ser *Serial) ConnectToController(portName string) bool {
-	c := &serial.Config{Name: portName, Baud: 9600, ReadTimeout: time.Millisecond * 100}
-	var err error
-	ser.port, err = serial.OpenPort(c)
+func (ser *Serial) ConnectToController(portName string) error {
+	port, err := OpenTransport(portName)
 	if err != nil {
-		ser.appGUI.ShowError(err, false)
-		return false
+		return err
 	}
-	err = ser.checkPort()
+	return ser.ConnectTransport(port, portName)
+}
+
+func (ser *Serial) ConnectTransport(port Transport, portName string) error {
+	framer := NewFramer()
+	status, err := ser.checkPort(port, framer)
 	if err != nil {
-		ser.port.Close()
-		ser.port = nil
+		port.Close()
+		return err
+	}
 
-		ser.appGUI.ShowError(err, false)
-		return false
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &serialConn{
+		cancel: cancel,
+		cmds:   make(chan serialCommand),
+		done:   make(chan struct{}),
 	}
+	ser.lock.Lock()
+	ser.conn = conn
+	ser.status = status
+	if ser.everConnected {
+		atomic.AddUint64(&ser.stats.Reconnects, 1)
+	}
+	ser.everConnected = true
+	ser.lock.Unlock()
 
-	ser.appGUI.UpdateStatusPage()
-	ser.appGUI.UpdateConfigPages()
-	ser.appGUI.UpdateConfig(portName)
+	ser.Publish(Connected{Port: portName})
 
+	events := make(chan serialEvent, EVENT_QUEUE_SIZE)
+	go ser.run(ctx, conn, port, framer, events)
+	go ser.dispatch(ctx, events)
 	go ser.queryConfig()
-	go ser.readPort()
 
-	return true
+	return nil
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSerialConcurrentRequests(t *testing.T) {
+	ser, _ := connectEmulator(t, &AppConfig{})
+	ctx, cancel := context.WithTimeout(context.Background(), TEST_TIMEOUT)
+	defer cancel()
+	base, err := ser.QueryConfig(ctx)
+	if err != nil {
+		t.Fatalf("query err=%v", err)
+	}
+
+	var wg sync.WaitGroup
+	run := func(name string, request func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				err := request(i)
+				if err == ErrNotConnected {
+					return
+				} else if err != nil {
+					t.Errorf("%s err=%v", name, err)
+					return
+				}
+			}
+		}()
+	}
+	for n := 0; n < 2; n++ {
+		run("Apply", func(i int) error {
+			config := base
+			config.Fan1Config.MinimumPower = int8(20 + i%50)
+			return ser.Apply(ctx, &config)
+		})
+		run("QueryConfig", func(int) error {
+			_, err := ser.QueryConfig(ctx)
+			return err
+		})
+	}
+	run("GetConfig", func(int) error {
+		ser.GetConfig()
+		ser.GetStatus()
+		if !ser.Connected() {
+			return ErrNotConnected
+		}
+		return nil
+	})
+
+	time.Sleep(time.Millisecond * 300)
+	ser.StopRead()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("requests still running after StopRead")
+	}
+}
+
+func TestSerialFallbackOnLinkLoss(t *testing.T) {
+	a, b := NewMemTransportPair()
+	em := NewEmulator()
+	if _, err := b.Write([]byte(em.statusStr() + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	ser := NewSerial(&AppConfig{})
+	fallback := em.config
+	fallback.Fan3Config.SensorControlling = SENSOR_A
+	ser.SetFallback(func() *Config { return &fallback })
+	if err := ser.ConnectTransport(a, "mem"); err != nil {
+		t.Fatalf("connect err=%v", err)
+	}
+
+	ser.Drop(errors.New("link lost"))
+	framer := NewFramer()
+	buf := make([]byte, 256)
+	for {
+		n, err := b.Read(buf)
+		if err != nil {
+			t.Fatalf("no fallback before close err=%v", err)
+		}
+		for _, frame := range framer.Feed(buf[:n]) {
+			if string(frame) == configToStr(&fallback) {
+				return
+			}
+		}
+	}
+}
+
+func TestMatchReplyTombstone(t *testing.T) {
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	now := time.Now()
+	late := serialCommand{ctx: expired, expect: REPLY_APPLY, written: now}
+	lost := serialCommand{ctx: expired, expect: REPLY_CONFIG, written: now.Add(-REPLY_LOST_TIMEOUT)}
+	next := serialCommand{ctx: context.Background(), expect: REPLY_APPLY, written: now}
+	pending := dropLost([]serialCommand{lost, late, next}, now)
+	if len(pending) != 2 {
+		t.Fatalf("%d pending after dropLost", len(pending))
+	}
+
+	pending, c := matchReply(pending, &Status{})
+	if c != nil || len(pending) != 2 {
+		t.Fatal("status matched a command")
+	}
+	pending, c = matchReply(pending, SuccessApply{})
+	if c == nil || c.ctx != expired {
+		t.Fatal("late reply didn't go to the tombstone")
+	}
+	pending, c = matchReply(pending, ErrorMessage{Message: "Invalid config"})
+	if c == nil || c.ctx != next.ctx || len(pending) != 0 {
+		t.Fatal("reply didn't go to the next command")
+	}
+
+	query := serialCommand{ctx: context.Background(), expect: REPLY_CONFIG, written: now}
+	pending, c = matchReply([]serialCommand{query}, ErrorMessage{Message: "Busy"})
+	if c == nil || c.expect != REPLY_CONFIG || len(pending) != 0 {
+		t.Fatal("ERR didn't answer the query")
+	}
+	if _, c = matchReply(nil, ErrorMessage{Message: " "}); c != nil {
+		t.Fatal("empty ERR matched")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	TEMP_ALARM_HYSTERESIS = 3
+	TEMP_ALARM_DURATION   = 5
+
+	TEMP_LEVEL_NORMAL   = 0
+	TEMP_LEVEL_WARNING  = 1
+	TEMP_LEVEL_CRITICAL = 2
+)
+
+// TempAlarm holds the limits of one sensor in the unit of the sensor, zero
+// disables a limit.
+type TempAlarm struct {
+	Warning  int
+	Critical int
+}
+
+func (ta *TempAlarm) limit(level int) int {
+	if level == TEMP_LEVEL_CRITICAL {
+		return ta.Critical
+	}
+	return ta.Warning
+}
+
+type tempAlarmState struct {
+	level int
+	since [3]time.Time
+}
+
+// TempAlarmMonitor raises alerts when a sensor stays at or above its warning
+// or critical limit for TempAlarmDuration seconds, they are cleared once the
+// temperature drops TempAlarmHysteresis below the limit. With
+// TempAlarmEmergency a critical alarm runs all fans at 100 % until no sensor
+// has been critical for TempAlarmDuration seconds, then the previous config is
+// restored.
+type TempAlarmMonitor struct {
+	serial    *Serial
+	alerts    *Alerts
+	alarms    [SENSOR_COUNT]TempAlarm
+	hyst      int
+	duration  time.Duration
+	emergency bool
+
+	lock      sync.Mutex
+	config    Config
+	states    [SENSOR_COUNT]tempAlarmState
+	previous  *Config
+	coolSince time.Time
+	applying  bool
+}
+
+func NewTempAlarmMonitor(serial *Serial, alerts *Alerts, appConfig *AppConfig) *TempAlarmMonitor {
+	monitor := &TempAlarmMonitor{
+		serial:    serial,
+		alerts:    alerts,
+		hyst:      appConfig.TempAlarmHysteresis,
+		duration:  time.Duration(appConfig.TempAlarmDuration) * time.Second,
+		emergency: appConfig.TempAlarmEmergency,
+	}
+	for name, alarm := range appConfig.TempAlarms {
+		sensor := sensorIndex(name)
+		if sensor < 0 {
+			log.Printf("Invalid sensor %q in TempAlarms", name)
+			continue
+		}
+		monitor.alarms[sensor] = alarm
+	}
+	return monitor
+}
+
+func (m *TempAlarmMonitor) OnEvent(ev Event) {
+	switch ev := ev.(type) {
+	case StatusReceived:
+		m.OnStatus(ev.Status)
+	case ConfigReceived:
+		m.lock.Lock()
+		m.config = ev.Config
+		m.lock.Unlock()
+	case Disconnected:
+		m.OnDisconnect(ev.Err)
+	case Closed:
+		m.OnDisconnect(nil)
+	}
+}
+
+func (m *TempAlarmMonitor) OnStatus(status Status) {
+	m.check(status, time.Now())
+}
+
+func (m *TempAlarmMonitor) check(status Status, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	critical := false
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		alarm := &m.alarms[sensor]
+		state := &m.states[sensor]
+		key := "sensor" + sensorNames[sensor] + ".temperature"
+		if m.config.SensorType(sensor) == SENSOR_NOT_CONNECTED {
+			*state = tempAlarmState{}
+			m.alerts.Clear(key)
+			continue
+		}
+		temp := int(status.Temperature(sensor))
+		level := state.level
+
+		for l := TEMP_LEVEL_WARNING; l <= TEMP_LEVEL_CRITICAL; l++ {
+			limit := alarm.limit(l)
+			if limit <= 0 || temp < limit {
+				state.since[l] = time.Time{}
+				continue
+			}
+			if state.since[l].IsZero() {
+				state.since[l] = now
+			}
+			if l > level && now.Sub(state.since[l]) >= m.duration {
+				level = l
+			}
+		}
+		for level > TEMP_LEVEL_NORMAL && (alarm.limit(level) <= 0 || temp < alarm.limit(level)-m.hyst) {
+			level--
+		}
+
+		if level != state.level {
+			state.level = level
+			unit := m.config.SensorUnit(sensor)
+			switch level {
+			case TEMP_LEVEL_NORMAL:
+				m.alerts.Clear(key)
+			case TEMP_LEVEL_WARNING:
+				m.alerts.Raise(key, ALERT_WARNING, fmt.Sprintf("Sensor %s reached %d %s, warning limit is %d %s", sensorNames[sensor], temp, unit, alarm.Warning, unit))
+			case TEMP_LEVEL_CRITICAL:
+				m.alerts.Raise(key, ALERT_CRITICAL, fmt.Sprintf("Sensor %s reached %d %s, critical limit is %d %s", sensorNames[sensor], temp, unit, alarm.Critical, unit))
+			}
+		}
+		if state.level == TEMP_LEVEL_CRITICAL {
+			critical = true
+		}
+	}
+
+	if !m.emergency || m.applying {
+		return
+	}
+	if critical {
+		m.coolSince = time.Time{}
+		// previous is set once the emergency config was applied, a failed
+		// apply is retried with the next status
+		if m.previous == nil {
+			previous := m.config
+			m.apply(emergencyConfig(previous), &previous, "Critical temperature, running all fans at 100 %")
+		}
+	} else if m.previous != nil {
+		if m.coolSince.IsZero() {
+			m.coolSince = now
+		}
+		if now.Sub(m.coolSince) >= m.duration {
+			m.apply(*m.previous, nil, "Temperatures back to normal, restoring previous config")
+		}
+	}
+}
+
+// emergencyConfig returns config with every fan under manual control at
+// 100 %.
+func emergencyConfig(config Config) Config {
+	for fan := 1; fan <= FAN_COUNT; fan++ {
+		fc := config.FanConfig(fan)
+		fc.SensorControlling = MANUAL_CONTROL
+		fc.MinimumPower = 100
+		fc.AllowStopped = false
+	}
+	return config
+}
+
+// apply sends config without blocking the event dispatch. With previous
+// it's the emergency config: TempEmergency suspends the fan curves before it's
+// sent and previous is kept once it was applied. Without previous the
+// emergency ends once config was applied.
+func (m *TempAlarmMonitor) apply(config Config, previous *Config, reason string) {
+	log.Printf("%s", reason)
+	m.applying = true
+	go func() {
+		if previous != nil {
+			m.serial.Publish(TempEmergency{Active: true})
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), APPLY_TIMEOUT)
+		defer cancel()
+		err := m.serial.Apply(ctx, &config)
+		if err != nil {
+			log.Printf("Temperature alarm couldn't apply config err=%v", err)
+		}
+		if (err == nil) == (previous == nil) {
+			m.serial.Publish(TempEmergency{Active: false})
+		}
+
+		m.lock.Lock()
+		m.applying = false
+		if err == nil {
+			m.previous = previous
+		}
+		m.lock.Unlock()
+	}()
+}
+
+// OnDisconnect clears the alarms but keeps the config to restore after an
+// emergency.
+func (m *TempAlarmMonitor) OnDisconnect(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for sensor := SENSOR_A; sensor <= SENSOR_D; sensor++ {
+		m.states[sensor] = tempAlarmState{}
+		m.alerts.Clear("sensor" + sensorNames[sensor] + ".temperature")
+	}
+	m.coolSince = time.Time{}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func alertLevels(alerts *Alerts) string {
+	var levels []string
+	for _, alert := range alerts.Active() {
+		levels = append(levels, alert.Key+"="+alert.Severity)
+	}
+	return strings.Join(levels, ",")
+}
+
+func TestTempAlarmMonitor(t *testing.T) {
+	alerts := NewAlerts(&AppConfig{})
+	m := NewTempAlarmMonitor(nil, alerts, &AppConfig{
+		TempAlarms: map[string]TempAlarm{
+			"a": {Warning: 40, Critical: 50},
+			"B": {Critical: 200},
+			"C": {Warning: 1},
+			"E": {Warning: 1},
+		},
+		TempAlarmHysteresis: 3,
+		TempAlarmDuration:   5,
+	})
+	m.OnEvent(ConfigReceived{Config: testConfig})
+
+	tests := []struct {
+		second int
+		a, b   int16
+		alerts string
+	}{
+		{0, 45, 77, ""},
+		{4, 45, 77, ""},
+		{5, 45, 77, "sensorA.temperature=warning"},
+		{6, 37, 77, "sensorA.temperature=warning"},
+		{7, 36, 77, ""},
+		{8, 55, 77, ""},
+		{12, 55, 77, ""},
+		{13, 55, 77, "sensorA.temperature=critical"},
+		{14, 47, 77, "sensorA.temperature=critical"},
+		{15, 46, 77, "sensorA.temperature=warning"},
+		{16, 20, 210, ""},
+		{17, 60, 210, ""},
+		{20, 39, 210, ""},
+		{21, 20, 210, "sensorB.temperature=critical"},
+		{22, 20, 197, "sensorB.temperature=critical"},
+		{23, 20, 196, ""},
+	}
+	start := time.Now()
+	for _, test := range tests {
+		status := Status{Temperatures: Temperatures{SensorA: test.a, SensorB: test.b, SensorC: 100}}
+		m.check(status, start.Add(time.Second*time.Duration(test.second)))
+		if levels := alertLevels(alerts); levels != test.alerts {
+			t.Errorf("at %d s: alerts %q, want %q", test.second, levels, test.alerts)
+		}
+		if test.alerts == "sensorB.temperature=critical" && !strings.Contains(alerts.Active()[0].Message, "210 °F") {
+			t.Errorf("message %q", alerts.Active()[0].Message)
+		}
+	}
+
+	m.check(Status{Temperatures: Temperatures{SensorA: 45}}, start.Add(time.Minute))
+	m.check(Status{Temperatures: Temperatures{SensorA: 45}}, start.Add(time.Minute*2))
+	m.OnEvent(Disconnected{})
+	if levels := alertLevels(alerts); levels != "" {
+		t.Errorf("alerts %q after disconnect", levels)
+	}
+}
+
+func TestTempAlarmEmergency(t *testing.T) {
+	sent := make(chan string, 4)
+	ser := scriptedController(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "FCS,") {
+			sent <- cmd
+		}
+		return acceptAll(cmd)
+	})
+	emergencies := make(chan bool, 4)
+	ser.Subscribe(func(ev Event) {
+		if ev, ok := ev.(TempEmergency); ok {
+			emergencies <- ev.Active
+		}
+	})
+	m := NewTempAlarmMonitor(ser, NewAlerts(&AppConfig{}), &AppConfig{
+		TempAlarms:          map[string]TempAlarm{"A": {Critical: 50}},
+		TempAlarmHysteresis: 3,
+		TempAlarmDuration:   5,
+		TempAlarmEmergency:  true,
+	})
+	m.OnEvent(ConfigReceived{Config: testConfig})
+
+	wait := func(want string, active bool) {
+		t.Helper()
+		select {
+		case v := <-emergencies:
+			if v != active {
+				t.Fatalf("TempEmergency %v, want %v", v, active)
+			}
+		case <-time.After(TEST_TIMEOUT):
+			t.Fatal("no TempEmergency")
+		}
+		select {
+		case v := <-sent:
+			if v != want {
+				t.Fatalf("sent %q, want %q", v, want)
+			}
+		case <-time.After(TEST_TIMEOUT):
+			t.Fatal("no config sent")
+		}
+		// the monitor takes the next status once the apply is done
+		for start := time.Now(); time.Since(start) < TEST_TIMEOUT; time.Sleep(time.Millisecond * 5) {
+			m.lock.Lock()
+			applying := m.applying
+			m.lock.Unlock()
+			if !applying {
+				return
+			}
+		}
+		t.Fatal("apply still running")
+	}
+
+	hot := Status{Temperatures: Temperatures{SensorA: 60}}
+	cool := Status{Temperatures: Temperatures{SensorA: 46}}
+	start := time.Now()
+	m.check(hot, start)
+	m.check(hot, start.Add(time.Second*5))
+	emergency := emergencyConfig(testConfig)
+	wait(configToStr(&emergency), true)
+
+	m.check(cool, start.Add(time.Second*6))
+	m.check(cool, start.Add(time.Second*10))
+	select {
+	case v := <-sent:
+		t.Fatalf("sent %q before cooling down", v)
+	default:
+	}
+	m.check(cool, start.Add(time.Second*11))
+	wait(configToStr(&testConfig), false)
+}
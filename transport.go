@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+const (
+	SERIAL_BAUD  = 9600
+	READ_TIMEOUT = time.Millisecond * 100
+	DIAL_TIMEOUT = time.Second * 3
+
+	TCP_SCHEME    = "tcp://"
+	SERIAL_SCHEME = "serial://"
+)
+
+// Transport is a byte stream to the controller. Read must not block longer
+// than READ_TIMEOUT: when no data arrives it returns 0 with a nil error or
+// io.EOF, any other error means the link is lost.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// OpenTransport opens the transport described by portName. "tcp://host:port"
+// connects to a raw TCP serial bridge (ser2net and alike), "serial://name" or
+// a plain device name opens a local serial port.
+func OpenTransport(portName string) (Transport, error) {
+	switch {
+	case strings.HasPrefix(portName, TCP_SCHEME):
+		return openTCPTransport(strings.TrimPrefix(portName, TCP_SCHEME))
+	case strings.HasPrefix(portName, SERIAL_SCHEME):
+		return openSerialTransport(strings.TrimPrefix(portName, SERIAL_SCHEME))
+	default:
+		return openSerialTransport(portName)
+	}
+}
+
+func openSerialTransport(name string) (Transport, error) {
+	c := &serial.Config{Name: name, Baud: SERIAL_BAUD, ReadTimeout: READ_TIMEOUT}
+	return serial.OpenPort(c)
+}
+
+type tcpTransport struct {
+	conn net.Conn
+}
+
+func openTCPTransport(addr string) (Transport, error) {
+	conn, err := net.DialTimeout("tcp", addr, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpTransport{conn: conn}, nil
+}
+
+func (t *tcpTransport) Read(b []byte) (int, error) {
+	t.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+	n, err := t.conn.Read(b)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return n, nil
+		}
+		if err == io.EOF {
+			return n, errors.New("Connection closed by remote host")
+		}
+	}
+	return n, err
+}
+
+func (t *tcpTransport) Write(b []byte) (int, error) {
+	return t.conn.Write(b)
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// MemTransport is one end of an in-memory transport pair, everything written
+// to one end can be read from the other, also after closing.
+type MemTransport struct {
+	in      <-chan []byte
+	out     chan<- []byte
+	pending []byte
+
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func NewMemTransportPair() (*MemTransport, *MemTransport) {
+	ab := make(chan []byte, 64)
+	ba := make(chan []byte, 64)
+	closed := make(chan struct{})
+	closeOnce := &sync.Once{}
+	a := &MemTransport{in: ba, out: ab, closed: closed, closeOnce: closeOnce}
+	b := &MemTransport{in: ab, out: ba, closed: closed, closeOnce: closeOnce}
+	return a, b
+}
+
+func (t *MemTransport) Read(b []byte) (int, error) {
+	if len(t.pending) == 0 {
+		select {
+		case d := <-t.in:
+			t.pending = d
+		case <-t.closed:
+			select {
+			case d := <-t.in:
+				t.pending = d
+			default:
+				return 0, io.ErrClosedPipe
+			}
+		case <-time.After(READ_TIMEOUT):
+			return 0, nil
+		}
+	}
+	n := copy(b, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *MemTransport) Write(b []byte) (int, error) {
+	d := append([]byte(nil), b...)
+	select {
+	case <-t.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	select {
+	case t.out <- d:
+		return len(b), nil
+	case <-t.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (t *MemTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const TEST_TIMEOUT = time.Second * 5
+
+// connectEmulator connects a Serial to an emulator over MemTransport, the
+// emulator end is returned to simulate link loss by closing it.
+func connectEmulator(t *testing.T, appConfig *AppConfig) (*Serial, *MemTransport) {
+	t.Helper()
+	a, b := NewMemTransportPair()
+	stop := make(chan struct{})
+	go NewEmulator().Serve(b, time.Millisecond*50, stop)
+	t.Cleanup(func() { close(stop) })
+
+	ser := NewSerial(appConfig)
+	if err := ser.ConnectTransport(a, "mem"); err != nil {
+		t.Fatalf("connect err=%v", err)
+	}
+	t.Cleanup(ser.StopRead)
+	return ser, b
+}
+
+func TestMemTransportPair(t *testing.T) {
+	a, b := NewMemTransportPair()
+	if _, err := a.Write([]byte("FCQ\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	var got []byte
+	for len(got) < 5 {
+		n, err := b.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "FCQ\r\n" {
+		t.Fatalf("got %q", got)
+	}
+
+	if n, err := b.Read(buf); n != 0 || err != nil {
+		t.Fatalf("idle read n=%d err=%v", n, err)
+	}
+	b.Close()
+	if _, err := a.Read(buf); err == nil {
+		t.Fatal("read after close didn't fail")
+	}
+	if _, err := a.Write([]byte("FCQ\r\n")); err == nil {
+		t.Fatal("write after close didn't fail")
+	}
+}
+
+func TestSerialOverMemTransport(t *testing.T) {
+	ser, peer := connectEmulator(t, &AppConfig{})
+	events := make(chan Event, 256)
+	ser.Subscribe(func(ev Event) {
+		select {
+		case events <- ev:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), TEST_TIMEOUT)
+	defer cancel()
+	config, err := ser.QueryConfig(ctx)
+	if err != nil {
+		t.Fatalf("query err=%v", err)
+	}
+	if config.Fan1Config.MaximumTemperature == 0 {
+		t.Fatalf("empty config %+v", config)
+	}
+
+	config.Fan1Config.MinimumPower = 45
+	if err := ser.Apply(ctx, &config); err != nil {
+		t.Fatalf("apply err=%v", err)
+	}
+	if got := ser.GetConfig(); got != config {
+		t.Fatalf("config after apply %+v", got.Fan1Config)
+	}
+
+	invalid := config
+	invalid.Fan1Config.MinimumPower = 120
+	if _, ok := ser.Apply(ctx, &invalid).(*ControllerError); !ok {
+		t.Fatal("invalid config wasn't rejected")
+	}
+
+	peer.Close()
+	for {
+		select {
+		case ev := <-events:
+			if _, ok := ev.(Disconnected); ok {
+				if ser.Connected() {
+					t.Fatal("still connected after Disconnected")
+				}
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("no Disconnected after closing the peer")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	STALE_TIMEOUT     = 5
+	WATCHDOG_INTERVAL = time.Second
+	ALERT_LINK_STALE  = "link.stale"
+)
+
+var ErrStale = errors.New("No data from fan controller")
+
+// Watchdog publishes LinkStale when no frame arrived for StaleTimeout
+// seconds while the port is open. With StaleReconnect the link is dropped
+// after that many seconds so the usual reconnect takes over.
+type Watchdog struct {
+	serial    *Serial
+	alerts    *Alerts
+	timeout   time.Duration
+	reconnect time.Duration
+
+	lock    sync.Mutex
+	last    time.Time
+	stale   bool
+	dropped bool
+	stop    chan struct{}
+}
+
+func NewWatchdog(serial *Serial, alerts *Alerts, appConfig *AppConfig) *Watchdog {
+	return &Watchdog{
+		serial:    serial,
+		alerts:    alerts,
+		timeout:   time.Duration(appConfig.StaleTimeout) * time.Second,
+		reconnect: time.Duration(appConfig.StaleReconnect) * time.Second,
+	}
+}
+
+func (w *Watchdog) Stale() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.stale
+}
+
+func (w *Watchdog) run(stop chan struct{}) {
+	ticker := time.NewTicker(WATCHDOG_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check(stop)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check raises the stale state, ticks after stop was closed are ignored.
+func (w *Watchdog) check(stop chan struct{}) {
+	w.lock.Lock()
+	if w.stop != stop {
+		w.lock.Unlock()
+		return
+	}
+	age := time.Since(w.last)
+	last := w.last
+	becameStale := !w.stale && age >= w.timeout
+	if becameStale {
+		w.stale = true
+	}
+	drop := w.stale && !w.dropped && w.reconnect > 0 && age >= w.reconnect
+	if drop {
+		w.dropped = true
+	}
+	w.lock.Unlock()
+
+	if becameStale {
+		w.alerts.Raise(ALERT_LINK_STALE, ALERT_WARNING, fmt.Sprintf("No data from controller since %s", last.Format("15:04:05")))
+		w.serial.Publish(LinkStale{Last: last})
+	}
+	if drop {
+		log.Printf("No data for %s, reconnecting", age.Truncate(time.Second))
+		w.serial.Drop(ErrStale)
+	}
+}
+
+// touch is called for every frame, it clears the stale state.
+func (w *Watchdog) touch() {
+	w.lock.Lock()
+	w.last = time.Now()
+	wasStale := w.stale
+	w.stale = false
+	w.lock.Unlock()
+
+	if wasStale {
+		w.alerts.Clear(ALERT_LINK_STALE)
+		w.serial.Publish(LinkFresh{})
+	}
+}
+
+func (w *Watchdog) OnEvent(ev Event) {
+	switch ev.(type) {
+	case Connected:
+		w.lock.Lock()
+		w.dropped = false
+		if w.stop == nil && w.timeout > 0 {
+			w.stop = make(chan struct{})
+			go w.run(w.stop)
+		}
+		w.lock.Unlock()
+		w.touch()
+	case StatusReceived, ConfigReceived, ApplySucceeded, ApplyFailed:
+		w.touch()
+	case Disconnected, Closed:
+		w.lock.Lock()
+		if w.stop != nil {
+			close(w.stop)
+			w.stop = nil
+		}
+		w.lock.Unlock()
+		w.touch()
+	}
+}
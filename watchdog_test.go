@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// watchEmulator connects a Serial with a Watchdog to an emulator, closing
+// the returned channel stops the emulator but keeps the port open.
+func watchEmulator(t *testing.T, appConfig *AppConfig) (*Serial, *Alerts, chan struct{}, chan Event) {
+	t.Helper()
+	a, b := NewMemTransportPair()
+	stop := make(chan struct{})
+	go NewEmulator().Serve(b, time.Millisecond*50, stop)
+
+	ser := NewSerial(appConfig)
+	alerts := NewAlerts(appConfig)
+	ser.Subscribe(NewWatchdog(ser, alerts, appConfig).OnEvent)
+	events := make(chan Event, 256)
+	ser.Subscribe(func(ev Event) {
+		switch ev.(type) {
+		case LinkStale, Disconnected:
+			events <- ev
+		}
+	})
+	if err := ser.ConnectTransport(a, "mem"); err != nil {
+		t.Fatalf("connect err=%v", err)
+	}
+	t.Cleanup(ser.StopRead)
+	return ser, alerts, stop, events
+}
+
+func staleAlert(alerts *Alerts) bool {
+	for _, alert := range alerts.Active() {
+		if alert.Key == ALERT_LINK_STALE {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWatchdogStale(t *testing.T) {
+	_, alerts, stop, events := watchEmulator(t, &AppConfig{StaleTimeout: 1, StaleReconnect: 2})
+	close(stop)
+
+	want := []string{"LinkStale", "Disconnected"}
+	for _, name := range want {
+		select {
+		case ev := <-events:
+			switch ev.(type) {
+			case LinkStale:
+				if name != "LinkStale" || !staleAlert(alerts) {
+					t.Fatalf("got LinkStale, want %s with alert", name)
+				}
+			case Disconnected:
+				if name != "Disconnected" {
+					t.Fatalf("got Disconnected, want %s", name)
+				}
+			}
+		case <-time.After(TEST_TIMEOUT):
+			t.Fatalf("no %s", name)
+		}
+	}
+}
+
+func TestWatchdogStopRead(t *testing.T) {
+	ser, alerts, stop, events := watchEmulator(t, &AppConfig{StaleTimeout: 1, StaleReconnect: 2})
+	defer close(stop)
+	ser.StopRead()
+
+	select {
+	case ev := <-events:
+		t.Fatalf("%T after StopRead", ev)
+	case <-time.After(WATCHDOG_INTERVAL * 3):
+	}
+	if staleAlert(alerts) {
+		t.Fatal("stale alert after StopRead")
+	}
+}